@@ -0,0 +1,36 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+// Auditor is told about every entry put and read, so that the history can be checked
+// for lost, duplicated or corrupted entries. See check.Recorder. Producers and consumers
+// are identified by names unique within the ring buffer, such as "p0" and "c1".
+//
+// The methods are called by the producers and consumers as they go, so they must be
+// safe for concurrent use, and they slow down the ring buffer.
+type Auditor interface {
+	// AuditConsumer is called when a consumer is created
+	AuditConsumer(consumer string, lossy bool)
+
+	// AuditPut is called after the entry is committed. The data is not compressed.
+	// first is the sequence of the first slot of the entry, and e.Seq of the last one.
+	// start and end are the Nanotime() before and after the put.
+	AuditPut(producer string, first int64, e Entry, start, end int64)
+
+	// AuditGet is called after the entry is read, including the entries that are then
+	// skipped because they expired or don't match the filter. The data is decompressed.
+	AuditGet(consumer string, e Entry, start, end int64)
+
+	// AuditRewind is called when the consumer rewinds to read the entries after seq again
+	AuditRewind(consumer string, seq int64)
+}
+
+// WithAuditor reports every entry put and read to a, for debugging. It's meant to be used
+// in tests or while investigating a problem, as the auditor sees every entry.
+func WithAuditor(a Auditor) Option {
+	return func(this *byteBuffer) error {
+		this.auditor = a
+		return nil
+	}
+}
@@ -7,9 +7,12 @@ import (
 	"encoding/binary"
 	"fmt"
 	"github.com/reducedb/ringbuffer"
+	"github.com/reducedb/ringbuffer/sequence"
+	"io"
 	"log"
-	//"math"
+	"math"
 	"sync"
+	"sync/atomic"
 )
 
 var _ = log.Ldate
@@ -27,6 +30,10 @@ const (
 	// Maximum number of slots the data item can occupy
 	MaxDataSlots = MaxDataSize / MinSlotSize
 
+	// Data size marking the slots up to the end of the buffer as skipped, when the
+	// buffer is created WithContiguous()
+	skipMarker = 0xffff
+
 	MaxProducerCount = 1
 )
 
@@ -35,35 +42,124 @@ var (
 	ErrDataExceedsMaxSize       = fmt.Errorf("bytebuffer: Data Size Exceeds MaxDataSize")
 	ErrDataExceedsMaxSlots      = fmt.Errorf("bytebuffer: Data Size Exceeds MaxDataSlots")
 	ErrSlotSizeTooSmall         = fmt.Errorf("bytebuffer: Slot Size Is Too Small")
+	ErrSlotSizeTooLarge         = fmt.Errorf("bytebuffer: Slot Size Exceeds MaxDataSize")
 	ErrNotPowerOfTwo            = fmt.Errorf("bytebuffer: Slot Count Must Be Power of Two")
 	ErrMaxProducerCountExceeded = fmt.Errorf("bytebuffer: This ringbuffer only allows %d producer(s)", MaxProducerCount)
 	ErrMaxDataSlotsExceeded     = fmt.Errorf("bytebuffer: Max Data Slots (%d) Exceeded", MaxDataSlots)
+	ErrHeaderDisabled           = fmt.Errorf("bytebuffer: Entry Header Not Enabled")
+	ErrClosed                   = fmt.Errorf("bytebuffer: Ring Buffer Closed")
+	ErrInvalidSequence          = fmt.Errorf("bytebuffer: Invalid Sequence")
+	ErrTimeout                  = fmt.Errorf("bytebuffer: Timed Out Waiting For Entry")
+	ErrNotPageAligned           = fmt.Errorf("bytebuffer: Buffer Size Must Be a Multiple of the Page Size")
+	ErrDoubleMappingUnsupported = fmt.Errorf("bytebuffer: Double Mapping Not Supported On This Platform")
 )
 
-//
+// RingBuffer is implemented by the ring buffers returned from New. It adds to
+// ringbuffer.RingBuffer the ability to configure each consumer.
+type RingBuffer interface {
+	ringbuffer.RingBuffer
+
+	NewConsumerWithOptions(...ConsumerOption) (Consumer, error)
+
+	// Close stops the producers from writing more entries. Consumers will continue to
+	// read the remaining entries, after which ErrClosed is returned.
+	Close() error
+
+	// Status returns a snapshot of the cursors of the producers and consumers.
+	Status() Status
+
+	// WriteSnapshot writes a copy of the slots and cursors that can be read back with
+	// ReadSnapshot.
+	WriteSnapshot(io.Writer) error
+}
+
+// Status is a snapshot of the state of the ring buffer
+type Status struct {
+	SlotSize  int
+	SlotCount int
+	Closed    bool
+
+	// Last sequence committed by each producer
+	Producers []int64
+
+	Consumers []ConsumerStatus
+}
+
+// ConsumerStatus is a snapshot of the state of a single consumer
+type ConsumerStatus struct {
+	// Last sequence committed by the consumer
+	Cursor int64
+
+	// Number of slots between the producers and the consumer
+	Lag int64
+
+	Lossy bool
+	Stats ConsumerStats
+}
+
 type byteBuffer struct {
 	buffer []byte
 
+	// mirror is buffer mapped twice in a row if mapped is set, so that the data
+	// wrapping around the end of buffer can be read and written in one piece
+	mirror []byte
+	mapped bool
+
+	// active counts the Puts and Gets in progress if mapped is set, and unmapped is set
+	// once the mapping is to be released, which happens after they have returned
+	active   int32
+	unmapped bool
+
+	// stamps holds the sequence last written to each slot, or -1 while it's being
+	// written. Lossy consumers check it before and after reading a slot, since the
+	// producers don't wait for them. The producers only stamp the slots once stamped is
+	// set, by the first lossy consumer created.
+	stamps  []int64
+	stamped int32
+
 	slotSize   int
 	slotCount  int
 	slotMask   int
 	bufferSize int64
 
+	// overhead is the number of bytes used by each entry in addition to the data,
+	// SlotOverhead plus HeaderSize if header is enabled
+	overhead   int
+	header     bool
+	contiguous bool
+
+	compressor Compressor
+
+	waitStrategy ringbuffer.WaitStrategy
+
+	// auditor, if set, is told about every entry put and read. producerIds and
+	// consumerIds number the producers and consumers it's told about.
+	auditor     Auditor
+	producerIds int
+	consumerIds int
+
 	tmpSize [2]byte
 	tmpbuf  []byte
 
 	producers []*producer
 	consumers []*consumer
 	mutex     sync.RWMutex
+
+	closed int32
 }
 
-var _ ringbuffer.RingBuffer = (*byteBuffer)(nil)
+var _ RingBuffer = (*byteBuffer)(nil)
 
-func New(slotSize, slotCount int) (ringbuffer.RingBuffer, error) {
+func New(slotSize, slotCount int, opts ...Option) (ringbuffer.RingBuffer, error) {
 	if slotSize < MinSlotSize {
 		return nil, ErrSlotSizeTooSmall
 	}
 
+	// A slot never holds more than MaxDataSize bytes of data
+	if slotSize > MaxDataSize {
+		return nil, ErrSlotSizeTooLarge
+	}
+
 	if slotCount > MaxDataSlots {
 		return nil, ErrMaxDataSlotsExceeded
 	}
@@ -72,23 +168,162 @@ func New(slotSize, slotCount int) (ringbuffer.RingBuffer, error) {
 		return nil, ErrNotPowerOfTwo
 	}
 
-	slotSize += SlotOverhead
-
 	d := &byteBuffer{
-		slotSize:   slotSize,
-		slotCount:  slotCount,
-		slotMask:   slotCount - 1,
-		bufferSize: int64(slotSize * slotCount),
-		buffer:     make([]byte, slotSize*slotCount),
-		producers:  make([]*producer, 0),
-		consumers:  make([]*consumer, 0),
+		slotCount:    slotCount,
+		slotMask:     slotCount - 1,
+		overhead:     SlotOverhead,
+		waitStrategy: ringbuffer.NewYieldingWait(),
+		producers:    make([]*producer, 0),
+		consumers:    make([]*consumer, 0),
+	}
+
+	for _, opt := range opts {
+		if err := opt(d); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.header {
+		d.overhead += HeaderSize
+	}
+
+	slotSize += d.overhead
+
+	d.slotSize = slotSize
+	d.bufferSize = int64(slotSize * slotCount)
+
+	if d.mapped {
+		mem, err := doubleMap(slotSize * slotCount)
+		if err != nil {
+			return nil, err
+		}
+
+		d.mirror = mem
+		d.buffer = mem[:d.bufferSize:d.bufferSize]
+	} else {
+		d.buffer = make([]byte, slotSize*slotCount)
+	}
+
+	d.stamps = make([]int64, slotCount)
+	for i := range d.stamps {
+		d.stamps[i] = sequence.InitialSequenceValue
 	}
 
 	return d, nil
 }
 
 func (this *byteBuffer) Close() error {
-	return nil
+	atomic.StoreInt32(&this.closed, 1)
+
+	// Wake up the producers waiting for room, and the consumers waiting for entries
+	this.mutex.RLock()
+	for _, p := range this.producers {
+		p.seq.Alert()
+	}
+
+	for _, c := range this.consumers {
+		c.reader.Interrupt()
+	}
+	this.mutex.RUnlock()
+
+	this.waitStrategy.Signal()
+	return this.release()
+}
+
+// release unmaps the double mapping once the ring buffer and all its consumers are
+// closed, so that no data returned by Get is used anymore. The mapping is not managed
+// by the garbage collector. The Puts and Gets still in progress return ErrClosed without
+// touching it, and release waits for them first.
+func (this *byteBuffer) release() error {
+	this.mutex.Lock()
+	done := this.mapped && !this.unmapped && this.isClosed() && len(this.consumers) == 0
+	if done {
+		this.unmapped = true
+	}
+	this.mutex.Unlock()
+
+	if !done {
+		return nil
+	}
+
+	for retries := 1; atomic.LoadInt32(&this.active) > 0; retries++ {
+		this.waitStrategy.Wait(retries)
+	}
+
+	return unmap(this.mirror)
+}
+
+// enter and leave bracket the Puts and Gets, which may access the double mapping
+func (this *byteBuffer) enter() {
+	if this.mapped {
+		atomic.AddInt32(&this.active, 1)
+	}
+}
+
+func (this *byteBuffer) leave() {
+	if this.mapped {
+		atomic.AddInt32(&this.active, -1)
+	}
+}
+
+func (this *byteBuffer) Status() Status {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	published := this.publishedLocked()
+
+	s := Status{
+		SlotSize:  this.slotSize,
+		SlotCount: this.slotCount,
+		Closed:    this.isClosed(),
+		Producers: make([]int64, 0, len(this.producers)),
+		Consumers: make([]ConsumerStatus, 0, len(this.consumers)),
+	}
+
+	for _, p := range this.producers {
+		cursor, _ := p.seq.Get()
+		s.Producers = append(s.Producers, cursor)
+	}
+
+	for _, c := range this.consumers {
+		cursor, _ := c.seq.Get()
+
+		// A lossy consumer is not tracked by its sequencer, as nobody waits for it
+		if c.lossy {
+			cursor = atomic.LoadInt64(&c.read)
+		}
+
+		s.Consumers = append(s.Consumers, ConsumerStatus{
+			Cursor: cursor,
+			Lag:    published - cursor,
+			Lossy:  c.lossy,
+			Stats:  c.Stats(),
+		})
+	}
+
+	return s
+}
+
+func (this *byteBuffer) isClosed() bool {
+	return atomic.LoadInt32(&this.closed) == 1
+}
+
+// publishedLocked returns the last sequence committed by all the producers. The
+// consumers don't use it while reading; they wait through their own sequencer instead.
+func (this *byteBuffer) publishedLocked() int64 {
+	if len(this.producers) == 0 {
+		return sequence.InitialSequenceValue
+	}
+
+	min := int64(math.MaxInt64)
+
+	for _, p := range this.producers {
+		if v, err := p.seq.Get(); err == nil && v < min {
+			min = v
+		}
+	}
+
+	return min
 }
 
 func (this *byteBuffer) Flush() error {
@@ -103,11 +338,23 @@ func (this *byteBuffer) Flush() error {
 //
 // Data must be []byte or ErrDataInvalid is returned.
 func (this *byteBuffer) Put(data []byte, seq int64) (int, error) {
-	needed, err := this.SlotsNeeded(len(data))
+	return this.PutEntry(&Entry{Data: data}, seq)
+}
+
+// PutEntry is the same as Put, but also writes the type and flags of the entry into the
+// extended header if it's enabled. If e.Timestamp is 0, the current Nanotime() is used.
+func (this *byteBuffer) PutEntry(e *Entry, seq int64) (int, error) {
+	needed, err := this.SlotsNeeded(len(e.Data))
 	if err != nil {
 		return 0, err
 	}
 
+	if this.isStamped() {
+		this.lockSlots(seq, needed)
+		defer this.stampSlots(seq, needed)
+	}
+
+	data := e.Data
 	n, i, l := len(data), 0, 0
 
 	slot := seq & int64(this.slotMask)
@@ -117,8 +364,23 @@ func (this *byteBuffer) Put(data []byte, seq int64) (int, error) {
 
 	index += SlotOverhead
 
+	if this.header {
+		h := *e
+		if h.Timestamp == 0 {
+			h.Timestamp = Nanotime()
+		}
+
+		putHeader(this.buffer[index:index+HeaderSize], &h)
+		index += HeaderSize
+	}
+
+	buffer := this.buffer
+	if this.mirror != nil {
+		buffer = this.mirror
+	}
+
 	for n > 0 {
-		l = copy(this.buffer[index:], data[i:])
+		l = copy(buffer[index:], data[i:])
 		i += l
 		n -= l
 
@@ -138,27 +400,53 @@ func (this *byteBuffer) Put(data []byte, seq int64) (int, error) {
 // if the data was wrapped in the ring buffer (part at the end, part at the beginning), then
 // a new []byte is allocated to hold the data. To the caller, it shouldn't matter however.
 func (this *byteBuffer) Get(seq int64) ([]byte, error) {
+	var e Entry
+
+	if err := this.GetEntry(seq, &e, &this.tmpbuf); err != nil {
+		return nil, err
+	}
+
+	return e.Data, nil
+}
+
+// GetEntry is the same as Get, but also reads the extended header into e if it's enabled.
+// If the data wraps around, it is copied into scratch, which is grown as needed.
+func (this *byteBuffer) GetEntry(seq int64, e *Entry, scratch *[]byte) error {
 	slot := seq & int64(this.slotMask)
 	index := slot * int64(this.slotSize)
 
 	n := int(binary.LittleEndian.Uint16(this.buffer[index : index+SlotOverhead]))
 	if n > MaxDataSize {
-		return nil, ErrDataExceedsMaxSize
+		return ErrDataExceedsMaxSize
 	}
 
 	index += SlotOverhead
 
-	if index+int64(n) < this.bufferSize {
-		return this.buffer[index : index+int64(n)], nil
+	if this.header {
+		getHeader(this.buffer[index:index+HeaderSize], e)
+		index += HeaderSize
+	}
+
+	if index+int64(n) <= this.bufferSize {
+		e.Data = this.buffer[index : index+int64(n)]
+		return nil
+	}
+
+	if this.mirror != nil {
+		e.Data = this.mirror[index : index+int64(n)]
+		return nil
 	}
 
-	if n > len(this.tmpbuf) {
-		this.tmpbuf = make([]byte, n)
+	if n > len(*scratch) {
+		*scratch = make([]byte, n)
 	}
+
+	// Limit the copy to n bytes, as scratch may be larger from a previous entry
+	tmpbuf := (*scratch)[:n]
 	l, i := 0, 0
 
 	for n > 0 {
-		l = copy(this.tmpbuf[i:], this.buffer[index:])
+		l = copy(tmpbuf[i:], this.buffer[index:])
 		n -= l
 		i += l
 
@@ -167,11 +455,12 @@ func (this *byteBuffer) Get(seq int64) ([]byte, error) {
 		}
 	}
 
-	return this.tmpbuf[:i], nil
+	e.Data = tmpbuf[:i]
+	return nil
 }
 
 // SlotSize returns the current slot size. This may be different than what the user originally
-// submitted since we have to add SlotOverhead
+// submitted since we have to add SlotOverhead, and HeaderSize if the header is enabled
 func (this *byteBuffer) SlotSize() int {
 	return this.slotSize
 }
@@ -187,13 +476,13 @@ func (this *byteBuffer) SlotsNeeded(size int) (int, error) {
 		return 0, ErrDataExceedsMaxSize
 	}
 
-    size += SlotOverhead
-	if size == SlotOverhead || size <= this.slotSize {
+	size += this.overhead
+	if size == this.overhead || size <= this.slotSize {
 		return 1, nil
 	}
 
 	//needed := int(math.Ceil(float64(size+SlotOverhead) / float64(this.slotSize)))
-    needed := 1 + ((size - 1) / this.slotSize)
+	needed := 1 + ((size - 1) / this.slotSize)
 
 	if needed > this.slotCount {
 		return 0, ErrDataExceedsMaxSlots
@@ -204,6 +493,76 @@ func (this *byteBuffer) SlotsNeeded(size int) (int, error) {
 	return needed, nil
 }
 
+// padding returns the number of slots to skip before an entry of needed slots starting
+// at seq, so that it doesn't wrap around the end of the buffer. It's always 0 unless the
+// buffer was created WithContiguous().
+func (this *byteBuffer) padding(seq int64, needed int) int {
+	if !this.contiguous {
+		return 0
+	}
+
+	if left := this.slotCount - int(seq&int64(this.slotMask)); needed > left {
+		return left
+	}
+
+	return 0
+}
+
+// putSkip marks the slots from seq up to the end of the buffer as skipped
+func (this *byteBuffer) putSkip(seq int64) {
+	if this.isStamped() {
+		this.lockSlots(seq, 1)
+		defer this.stampSlots(seq, 1)
+	}
+
+	index := (seq & int64(this.slotMask)) * int64(this.slotSize)
+	binary.LittleEndian.PutUint16(this.buffer[index:index+SlotOverhead], skipMarker)
+}
+
+func (this *byteBuffer) isStamped() bool {
+	return atomic.LoadInt32(&this.stamped) == 1
+}
+
+// lockSlots marks the n slots from seq as being written. Swapping the stamps also orders
+// the write after the reads of the lossy consumers that checked the slots.
+func (this *byteBuffer) lockSlots(seq int64, n int) {
+	for i := int64(0); i < int64(n); i++ {
+		atomic.SwapInt64(&this.stamps[(seq+i)&int64(this.slotMask)], -1)
+	}
+}
+
+// stampSlots marks the n slots from seq as written with their sequences
+func (this *byteBuffer) stampSlots(seq int64, n int) {
+	for i := int64(0); i < int64(n); i++ {
+		atomic.StoreInt64(&this.stamps[(seq+i)&int64(this.slotMask)], seq+i)
+	}
+}
+
+// intact returns true if the n slots from seq still hold what was written at seq. The
+// slots read between two calls that return true were not overwritten in the meantime.
+func (this *byteBuffer) intact(seq int64, n int) bool {
+	for i := int64(0); i < int64(n); i++ {
+		s := seq + i
+
+		// Swapping the stamp with itself orders our reads before the next write
+		if !atomic.CompareAndSwapInt64(&this.stamps[s&int64(this.slotMask)], s, s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// skipped returns the last sequence skipped if the slot at seq is marked as skipped,
+// or -1 otherwise
+func (this *byteBuffer) skipped(seq int64) int64 {
+	if !this.contiguous || this.NextDataSize(seq) != skipMarker {
+		return -1
+	}
+
+	return seq | int64(this.slotMask)
+}
+
 func (this *byteBuffer) NextDataSize(seq int64) int {
 	slot := seq & int64(this.slotMask)
 	index := slot * int64(this.slotSize)
@@ -5,6 +5,8 @@ package bytebuffer
 
 import (
 	"bytes"
+	"github.com/reducedb/ringbuffer"
+	"github.com/reducedb/ringbuffer/ringtest"
 	"log"
 	"testing"
 )
@@ -31,6 +33,12 @@ func TestErrSlotSizeTooSmall(t *testing.T) {
 	}
 }
 
+func TestErrSlotSizeTooLarge(t *testing.T) {
+	if _, err := New(MaxDataSize+1, 128); err != ErrSlotSizeTooLarge {
+		t.Fatal("Should have exited with ErrSlotSizeTooLarge, " + err.Error())
+	}
+}
+
 func TestErrNotPowerOfTwo(t *testing.T) {
 	if _, err := New(2, 10); err != ErrNotPowerOfTwo {
 		t.Fatal("Should have exited with ErrNotPowerOfTwo, " + err.Error())
@@ -186,6 +194,36 @@ func TestReadWrap(t *testing.T) {
 	}
 }
 
+func TestReadWrapAfterLargerEntry(t *testing.T) {
+	b, err := New(4, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := b.(*byteBuffer)
+
+	// The first entry wraps and grows the scratch buffer to 20 bytes
+	large := make([]byte, 20)
+	st.Put(large, 13)
+
+	if _, err := st.Get(13); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second entry wraps too, and must not be padded to the size of the scratch buffer
+	data := []byte{1, 2, 3, 4, 5, 6}
+	st.Put(data, 15)
+
+	out, err := st.Get(15)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out, data) {
+		t.Fatalf("Expect %v, got %v", data, out)
+	}
+}
+
 func TestReadMaxDataSize(t *testing.T) {
 	b, err := New(10, 128)
 	if err != nil {
@@ -203,6 +241,21 @@ func TestReadMaxDataSize(t *testing.T) {
 	}
 }
 
+func TestConformance(t *testing.T) {
+	ringtest.Conformance(t, ringtest.Engine{
+		New: func() (ringbuffer.RingBuffer, error) {
+			return New(4, 8)
+		},
+		Entry: func(i int) interface{} {
+			return bytes.Repeat([]byte{byte(i)}, i%7)
+		},
+		Check: func(v interface{}, i int) bool {
+			return bytes.Equal(v.([]byte), bytes.Repeat([]byte{byte(i)}, i%7))
+		},
+		ErrClosed: ErrClosed,
+	})
+}
+
 func BenchmarkPut(b *testing.B) {
 	buf, err := New(10, 128)
 	if err != nil {
@@ -0,0 +1,111 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+import (
+	"context"
+	"log"
+)
+
+var _ = log.Ldate
+
+// Pump puts every []byte received from ch into p. When ch is closed, p is closed and nil
+// is returned, so the consumers see the end of the stream. If ctx is done first, p is
+// closed as well, which wakes up a Put waiting for room, and ctx.Err() is returned.
+//
+// Either way, closing p closes the whole ring buffer, since it only has one producer:
+// the consumers read the remaining entries and then get ErrClosed.
+func Pump(ctx context.Context, p Producer, ch <-chan []byte) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	// Close the producer on cancellation to wake up a blocked Put
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			p.Close()
+			return ctx.Err()
+
+		case data, ok := <-ch:
+			if !ok {
+				return p.Close()
+			}
+
+			if _, err := p.Put(data); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+
+				return err
+			}
+		}
+	}
+}
+
+// Chan sends a copy of every entry read from c to the returned channel, which has a
+// buffer of size entries. The entries are copied so they remain valid after the producer
+// reuses the slots.
+//
+// The data channel is closed when the ring buffer is closed, when c returns an error,
+// or when ctx is done, in which case c is closed as well. The error channel then
+// receives nil if the ring buffer was closed, or the error that stopped the consumer.
+func Chan(ctx context.Context, c Consumer, size int) (<-chan []byte, <-chan error) {
+	out := make(chan []byte, size)
+	errc := make(chan error, 1)
+	done := make(chan struct{})
+
+	// Wake up a blocked GetEntry on cancellation. The consumer is only closed by the
+	// reader, once it's done copying the entry, so its slots can't be reused mid-copy.
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Interrupt()
+		case <-done:
+		}
+	}()
+
+	go func() {
+		defer close(errc)
+		defer close(out)
+		defer close(done)
+
+		for {
+			e, err := c.GetEntry()
+			if ctx.Err() != nil {
+				c.Close()
+				errc <- ctx.Err()
+				return
+			}
+
+			if err == ErrClosed {
+				errc <- nil
+				return
+			} else if err != nil {
+				errc <- err
+				return
+			}
+
+			data := make([]byte, len(e.Data))
+			copy(data, e.Data)
+
+			select {
+			case out <- data:
+			case <-ctx.Done():
+				c.Close()
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+
+	return out, errc
+}
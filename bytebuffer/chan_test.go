@@ -0,0 +1,157 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"testing"
+	"time"
+)
+
+var _ = log.Ldate
+
+func TestPumpAndChan(t *testing.T) {
+	r, err := New(16, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.(RingBuffer).NewConsumerWithOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var count = 1000
+
+	in := make(chan []byte)
+	pumpErr := make(chan error, 1)
+
+	go func() {
+		pumpErr <- Pump(context.Background(), p.(Producer), in)
+	}()
+
+	go func() {
+		for i := 0; i < count; i++ {
+			in <- []byte{byte(i), byte(i >> 8)}
+		}
+		close(in)
+	}()
+
+	out, errc := Chan(context.Background(), c, 4)
+
+	var total int
+
+	for data := range out {
+		if !bytes.Equal(data, []byte{byte(total), byte(total >> 8)}) {
+			t.Fatalf("Expect %d, got %v", total, data)
+		}
+
+		total++
+	}
+
+	if total != count {
+		t.Fatalf("Expected to have read %d items, got %d", count, total)
+	}
+
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-pumpErr; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChanCancel(t *testing.T) {
+	r, err := New(16, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.(RingBuffer).NewConsumerWithOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	out, errc := Chan(ctx, c, 0)
+
+	if _, err := p.Put([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if data := <-out; !bytes.Equal(data, []byte{1}) {
+		t.Fatalf("Expect [1], got %v", data)
+	}
+
+	cancel()
+
+	for range out {
+	}
+
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("Expect context.Canceled, got %v", err)
+	}
+
+	// The consumer is detached, so the producer can now lap it without blocking
+	for i := 0; i < 10; i++ {
+		if _, err := p.Put([]byte{2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPumpCancel(t *testing.T) {
+	r, err := New(16, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The consumer never reads, so the third Put waits for room
+	if _, err := r.NewConsumer(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	in := make(chan []byte, 3)
+	for i := 0; i < 3; i++ {
+		in <- []byte{byte(i)}
+	}
+
+	pumpErr := make(chan error, 1)
+
+	go func() {
+		pumpErr <- Pump(ctx, p.(Producer), in)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	if err := <-pumpErr; err != context.Canceled {
+		t.Fatalf("Expect context.Canceled, got %v", err)
+	}
+
+	if !r.(RingBuffer).Status().Closed {
+		t.Fatal("Expect the ring buffer to be closed with its producer")
+	}
+}
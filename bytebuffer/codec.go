@@ -0,0 +1,134 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"github.com/reducedb/ringbuffer"
+	"log"
+)
+
+var _ = log.Ldate
+
+// Codec converts structured values to and from the bytes stored in the ring buffer.
+type Codec interface {
+	Encode(v interface{}) ([]byte, error)
+
+	// Decode decodes data into v, which must be a pointer. data is only valid during
+	// the call, so the codec must not retain it.
+	Decode(data []byte, v interface{}) error
+}
+
+var (
+	// GobCodec encodes values with encoding/gob. Each entry is encoded with a new
+	// encoder so it is self-describing, at the cost of repeating the type information.
+	GobCodec Codec = gobCodec{}
+
+	// JSONCodec encodes values with encoding/json.
+	JSONCodec Codec = jsonCodec{}
+)
+
+type gobCodec struct{}
+
+func (gobCodec) Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Decode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Decode(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// CodecProducer wraps a producer so that Put accepts any value the codec can encode.
+type CodecProducer struct {
+	producer ringbuffer.Producer
+	codec    Codec
+}
+
+var _ ringbuffer.Producer = (*CodecProducer)(nil)
+
+func NewCodecProducer(p ringbuffer.Producer, codec Codec) *CodecProducer {
+	return &CodecProducer{
+		producer: p,
+		codec:    codec,
+	}
+}
+
+// Put encodes v and writes it to the ring buffer. The size of the encoded value is
+// checked the same way as any other entry, so ErrDataExceedsMaxSize or
+// ErrDataExceedsMaxSlots is returned if it does not fit.
+func (this *CodecProducer) Put(v interface{}) (int, error) {
+	data, err := this.codec.Encode(v)
+	if err != nil {
+		return 0, err
+	}
+
+	return this.producer.Put(data)
+}
+
+// CodecConsumer wraps a consumer so that Get returns decoded values.
+type CodecConsumer struct {
+	consumer ringbuffer.Consumer
+	codec    Codec
+	factory  func() interface{}
+}
+
+var _ ringbuffer.Consumer = (*CodecConsumer)(nil)
+
+// NewCodecConsumer returns a CodecConsumer that decodes each entry into a new value
+// returned by factory, which must return a pointer. factory is only needed by Get.
+func NewCodecConsumer(c ringbuffer.Consumer, codec Codec, factory func() interface{}) *CodecConsumer {
+	return &CodecConsumer{
+		consumer: c,
+		codec:    codec,
+		factory:  factory,
+	}
+}
+
+// Get decodes the next entry into a new value from the factory and returns it.
+func (this *CodecConsumer) Get() (interface{}, error) {
+	if this.factory == nil {
+		return nil, ErrDataInvalid
+	}
+
+	v := this.factory()
+
+	if err := this.GetInto(v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// GetInto decodes the next entry into v, which must be a pointer.
+func (this *CodecConsumer) GetInto(v interface{}) error {
+	out, err := this.consumer.Get()
+	if err != nil {
+		return err
+	}
+
+	data, ok := out.([]byte)
+	if !ok {
+		return ErrDataInvalid
+	}
+
+	return this.codec.Decode(data, v)
+}
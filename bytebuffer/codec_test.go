@@ -0,0 +1,99 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+import (
+	"log"
+	"reflect"
+	"testing"
+)
+
+var _ = log.Ldate
+
+type codecTestMessage struct {
+	Symbol string
+	Price  float64
+	Size   int64
+}
+
+func testCodec(t *testing.T, codec Codec) {
+	r, err := New(32, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.NewConsumer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cp := NewCodecProducer(p, codec)
+	cc := NewCodecConsumer(c, codec, func() interface{} { return &codecTestMessage{} })
+
+	msgs := []codecTestMessage{
+		{"GOOG", 1034.5, 100},
+		{"AAPL", 550.25, 2500},
+		{"MSFT", 38.1, 1},
+	}
+
+	for _, m := range msgs {
+		if _, err := cp.Put(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, m := range msgs {
+		if i%2 == 0 {
+			v, err := cc.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(*v.(*codecTestMessage), m) {
+				t.Fatalf("Expect %v, got %v", m, v)
+			}
+		} else {
+			var v codecTestMessage
+
+			if err := cc.GetInto(&v); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(v, m) {
+				t.Fatalf("Expect %v, got %v", m, v)
+			}
+		}
+	}
+}
+
+func TestGobCodec(t *testing.T) {
+	testCodec(t, GobCodec)
+}
+
+func TestJSONCodec(t *testing.T) {
+	testCodec(t, JSONCodec)
+}
+
+func TestCodecDataExceedsMaxSlots(t *testing.T) {
+	r, err := New(4, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cp := NewCodecProducer(p, JSONCodec)
+
+	if _, err := cp.Put(codecTestMessage{"GOOG", 1034.5, 100}); err != ErrDataExceedsMaxSlots {
+		t.Fatalf("Should have exited with ErrDataExceedsMaxSlots, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"log"
+	"sync"
+)
+
+var _ = log.Ldate
+
+const (
+	// FlagCompressed marks entries whose data was compressed by the producer
+	FlagCompressed = 0x8000
+
+	// Entries smaller than this are never compressed
+	MinCompressSize = 64
+)
+
+// Compressor compresses the data of individual entries. Both methods append the result
+// to dst[:0] and return it, so the caller can reuse dst between calls. Decompress may be
+// called concurrently by several consumers.
+type Compressor interface {
+	Compress(dst, src []byte) ([]byte, error)
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+type flateCompressor struct {
+	level   int
+	writers sync.Pool
+	readers sync.Pool
+}
+
+var _ Compressor = (*flateCompressor)(nil)
+
+// NewFlateCompressor returns a Compressor based on compress/flate with the given level.
+func NewFlateCompressor(level int) (Compressor, error) {
+	if _, err := flate.NewWriter(nil, level); err != nil {
+		return nil, err
+	}
+
+	return &flateCompressor{level: level}, nil
+}
+
+func (this *flateCompressor) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst[:0])
+
+	w, _ := this.writers.Get().(*flate.Writer)
+	if w == nil {
+		w, _ = flate.NewWriter(buf, this.level)
+	} else {
+		w.Reset(buf)
+	}
+
+	defer this.writers.Put(w)
+
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (this *flateCompressor) Decompress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst[:0])
+	br := bytes.NewReader(src)
+
+	r, _ := this.readers.Get().(io.ReadCloser)
+	if r == nil {
+		r = flate.NewReader(br)
+	} else {
+		r.(flate.Resetter).Reset(br, nil)
+	}
+
+	defer this.readers.Put(r)
+
+	// Never decompress more than an entry can hold
+	if n, err := buf.ReadFrom(io.LimitReader(r, MaxDataSize+1)); err != nil {
+		return nil, err
+	} else if n > MaxDataSize {
+		return nil, ErrDataExceedsMaxSize
+	}
+
+	return buf.Bytes(), nil
+}
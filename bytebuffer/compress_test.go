@@ -0,0 +1,113 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+import (
+	"bytes"
+	"compress/flate"
+	"log"
+	"math/rand"
+	"testing"
+)
+
+var _ = log.Ldate
+
+func TestFlateCompressor(t *testing.T) {
+	c, err := NewFlateCompressor(flate.BestSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := bytes.Repeat([]byte("compressible log line "), 100)
+
+	var zbuf, buf []byte
+
+	for i := 0; i < 3; i++ {
+		if zbuf, err = c.Compress(zbuf, src); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(zbuf) >= len(src) {
+			t.Fatalf("Expect compressed size < %d, got %d", len(src), len(zbuf))
+		}
+
+		if buf, err = c.Decompress(buf, zbuf); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(buf, src) {
+			t.Fatalf("bytes not the same")
+		}
+	}
+}
+
+func TestErrInvalidCompressionLevel(t *testing.T) {
+	if _, err := NewFlateCompressor(42); err == nil {
+		t.Fatal("Should have exited with an error")
+	}
+}
+
+func TestProducerAndConsumerCompression(t *testing.T) {
+	fc, err := NewFlateCompressor(flate.DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := New(64, 64, WithCompression(fc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.NewConsumer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := r.(*byteBuffer)
+
+	small := []byte("short")
+	large := bytes.Repeat([]byte("0123456789"), 200)
+	random := make([]byte, 100)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	start := int64(0)
+
+	for i, data := range [][]byte{small, large, random} {
+		if _, err := p.(Producer).PutEntry(1, 2, data); err != nil {
+			t.Fatal(err)
+		}
+
+		e, err := c.(Consumer).GetEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(e.Data, data) {
+			t.Fatalf("%d: bytes not the same", i)
+		}
+
+		if e.Flags != 2 {
+			t.Fatalf("%d: Expect flags == 2, got %d", i, e.Flags)
+		}
+
+		// Only the large entry is compressible
+		var raw Entry
+		var scratch []byte
+
+		if err := st.GetEntry(start, &raw, &scratch); err != nil {
+			t.Fatal(err)
+		}
+
+		start = e.Seq + 1
+
+		if compressed := raw.Flags&FlagCompressed != 0; compressed != (i == 1) {
+			t.Fatalf("%d: Expect compressed == %t", i, i == 1)
+		}
+	}
+}
@@ -4,69 +4,529 @@
 package bytebuffer
 
 import (
+	"fmt"
 	"github.com/reducedb/ringbuffer"
 	"github.com/reducedb/ringbuffer/sequence"
 	"log"
+	"sync/atomic"
+	"time"
 )
 
 var _ = log.Ldate
 
+// Consumer is implemented by the consumers returned from NewConsumer. It adds to
+// ringbuffer.Consumer the ability to read the extended header of each entry.
+type Consumer interface {
+	ringbuffer.Consumer
+
+	// GetEntry reads the next entry from the ring buffer. Same as Get, Entry.Data is
+	// only valid until the next call.
+	GetEntry() (Entry, error)
+
+	// Stats returns the counters of the consumer. It is safe to call from any goroutine.
+	Stats() ConsumerStats
+
+	// Close detaches the consumer from the ring buffer so the producers no longer wait
+	// for it. Pending and future calls to Get return ErrClosed.
+	Close() error
+
+	// Interrupt wakes up a pending Get, which returns the entries already committed and
+	// then ErrClosed, without detaching the consumer from the producers. The consumer
+	// still holds back the producers until it's closed.
+	Interrupt()
+
+	// Commit releases all the entries up to and including the one with sequence seq,
+	// so the producers can reuse their slots. It is only needed WithManualCommit(), and
+	// may be called from a different goroutine than Get. ErrInvalidSequence is returned
+	// if seq has not been read yet, or is before the last sequence committed.
+	Commit(seq int64) error
+
+	// ManualCommit returns true if the consumer was created WithManualCommit()
+	ManualCommit() bool
+
+	// Rewind moves the read position back so the next entry returned is the one after
+	// seq, which must be the Seq of an entry that has been read, or -1 before the first
+	// entry. Entries that have been committed cannot be read again, in which case
+	// ErrInvalidSequence is returned. It is only useful WithManualCommit().
+	Rewind(seq int64) error
+}
+
+// ConsumerStats contains the counters of a single consumer
+type ConsumerStats struct {
+	// Number of entries returned by Get or GetEntry
+	Entries uint64
+
+	// Number of entries skipped because their TTL expired before they were consumed
+	Expired uint64
+
+	// Number of entries skipped because they did not match the filter
+	Filtered uint64
+
+	// Number of slots skipped by a lossy consumer because the producers overwrote
+	// them before they were read
+	Lost uint64
+}
+
+// ConsumerOption configures the consumer created by NewConsumerWithOptions.
+type ConsumerOption func(*consumer) error
+
+// WithExpiryHandler calls fn for every entry that's skipped because its TTL expired.
+// Entry.Data is only valid during the call.
+func WithExpiryHandler(fn func(Entry)) ConsumerOption {
+	return func(this *consumer) error {
+		this.expired = fn
+		return nil
+	}
+}
+
+// WithFilter only returns the entries for which fn returns true. The other entries are
+// committed past without being returned. Entry.Data is only valid during the call.
+func WithFilter(fn func(Entry) bool) ConsumerOption {
+	return func(this *consumer) error {
+		this.filter = fn
+		return nil
+	}
+}
+
+// WithTypes only returns the entries with one of the types listed. It requires the
+// extended header to be enabled.
+func WithTypes(types ...uint16) ConsumerOption {
+	return func(this *consumer) error {
+		if !this.buffer.header {
+			return ErrHeaderDisabled
+		}
+
+		m := make(map[uint16]bool, len(types))
+		for _, t := range types {
+			m[t] = true
+		}
+
+		this.filter = func(e Entry) bool {
+			return m[e.Type]
+		}
+
+		return nil
+	}
+}
+
+// WithManualCommit stops the consumer from committing the entries it reads. They remain
+// in the ring buffer, and the producers are not allowed to overwrite them, until Commit
+// is called with the sequence of the entry (Entry.Seq).
+func WithManualCommit() ConsumerOption {
+	return func(this *consumer) error {
+		this.manual = true
+		return nil
+	}
+}
+
+// WithTimeout makes Get return ErrTimeout if no entry becomes available within d. The
+// consumer can be used again afterwards.
+func WithTimeout(d time.Duration) ConsumerOption {
+	return func(this *consumer) error {
+		this.barrier.SetTimeout(d)
+		return nil
+	}
+}
+
+// WithLossy creates a consumer that the producers never wait for, so it cannot stall
+// them. If it falls behind by more than the ring buffer can hold, it skips all the
+// entries already put and counts the skipped slots in ConsumerStats.Lost. A lossy consumer starts
+// with the next entry put, and always copies the data, so reading is slower. Entries
+// overwritten while being copied are detected and skipped, so they are never torn.
+func WithLossy() ConsumerOption {
+	return func(this *consumer) error {
+		this.lossy = true
+		return nil
+	}
+}
+
 type consumer struct {
 	buffer *byteBuffer
 	seq    ringbuffer.Sequencer
+	reader *sequence.Reader
+
+	// barrier holds the producer sequences the consumer waits for
+	barrier *sequence.Barrier
+
+	// id identifies the consumer to the auditor
+	id string
+
+	// tmpbuf holds the data of entries that wrapped around the end of the buffer
+	tmpbuf []byte
+
+	// zbuf holds the decompressed data of the current entry
+	zbuf []byte
+
+	expired func(Entry)
+	filter  func(Entry) bool
+
+	// read is the sequence of the last slot read, which is ahead of the committed
+	// sequence until the entries are committed. It's only written by the goroutine
+	// calling Get, and atomically so that Status can read it.
+	read   int64
+	manual bool
+
+	lossy bool
+	lbuf  []byte
+
+	// The last entry returned is committed by the following call, so that its data
+	// cannot be overwritten while the caller is still using it.
+	last    int64
+	pending bool
+
+	stats ConsumerStats
 }
 
-var _ ringbuffer.Consumer = (*consumer)(nil)
+var _ Consumer = (*consumer)(nil)
 
 func (this *byteBuffer) NewConsumer() (ringbuffer.Consumer, error) {
+	return this.NewConsumerWithOptions()
+}
+
+func (this *byteBuffer) NewConsumerWithOptions(opts ...ConsumerOption) (Consumer, error) {
 	this.mutex.Lock()
 	defer this.mutex.Unlock()
 
+	if this.unmapped {
+		return nil, ErrClosed
+	}
+
 	seq, err := sequence.NewConsumer(this.SlotCount())
 	if err != nil {
 		return nil, err
 	}
 
+	seq.SetWaitStrategy(this.waitStrategy)
+
 	c := &consumer{
-		buffer: this,
-		seq:    seq,
+		buffer:  this,
+		seq:     seq,
+		barrier: seq.(*sequence.Consumer).Barrier(),
+		id:      fmt.Sprintf("c%d", this.consumerIds),
+		read:    sequence.InitialSequenceValue,
+	}
+
+	c.reader = sequence.NewReader(seq, c.barrier, sequence.InitialSequenceValue)
+
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
 	}
 
 	this.consumers = append(this.consumers, c)
+	this.consumerIds++
+
+	if this.auditor != nil {
+		this.auditor.AuditConsumer(c.id, c.lossy)
+	}
+
+	// A lossy consumer starts with the next entry and never holds back the producers.
+	// The producers stamp the slots from now on, before the start is picked, so the
+	// slots it reads can be checked.
+	if c.lossy {
+		atomic.StoreInt32(&this.stamped, 1)
+		c.read = this.publishedLocked()
+	}
 
 	for _, p := range this.producers {
-		p.seq.AddGatingSequence(c.seq)
+		if !c.lossy {
+			p.seq.AddGatingSequence(c.seq)
+		}
+
 		c.seq.AddGatingSequence(p.seq)
 	}
 
+	// Get would otherwise wait for the next Put forever
+	if this.isClosed() {
+		c.reader.Interrupt()
+	}
+
 	return c, nil
 }
 
+// removeConsumer detaches c from the producers and forgets about it
+func (this *byteBuffer) removeConsumer(c *consumer) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for i, v := range this.consumers {
+		if v == c {
+			this.consumers = append(this.consumers[:i], this.consumers[i+1:]...)
+			break
+		}
+	}
+
+	for _, p := range this.producers {
+		p.seq.RemoveGatingSequence(c.seq)
+	}
+}
+
 func (this *consumer) Get() (interface{}, error) {
-	seq, err := this.seq.Request(1)
+	e, err := this.GetEntry()
 	if err != nil {
 		return 0, err
 	}
 
-	size := this.buffer.NextDataSize(seq)
-	needed, err := this.buffer.SlotsNeeded(size)
-	if err != nil {
-		return 0, err
+	return e.Data, nil
+}
+
+// GetEntry reads the next entry from the ring buffer. Entries with an expired TTL, or
+// that don't match the filter, are committed past without being returned. The entry
+// returned is committed by the next call, so its data remains valid until then.
+func (this *consumer) GetEntry() (Entry, error) {
+	this.buffer.enter()
+	defer this.buffer.leave()
+
+	for {
+		e, err := this.next()
+		if err != nil {
+			return e, err
+		}
+
+		if e.Expires != 0 && Nanotime() > e.Expires {
+			atomic.AddUint64(&this.stats.Expired, 1)
+
+			if this.expired != nil {
+				this.expired(e)
+			}
+
+			this.release(e.Seq)
+			continue
+		}
+
+		if this.filter != nil && !this.filter(e) {
+			atomic.AddUint64(&this.stats.Filtered, 1)
+			this.release(e.Seq)
+			continue
+		}
+
+		atomic.AddUint64(&this.stats.Entries, 1)
+		this.release(e.Seq)
+
+		return e, nil
+	}
+}
+
+func (this *consumer) Stats() ConsumerStats {
+	return ConsumerStats{
+		Entries:  atomic.LoadUint64(&this.stats.Entries),
+		Expired:  atomic.LoadUint64(&this.stats.Expired),
+		Filtered: atomic.LoadUint64(&this.stats.Filtered),
+		Lost:     atomic.LoadUint64(&this.stats.Lost),
+	}
+}
+
+func (this *consumer) Commit(seq int64) error {
+	// The entries after read may not even have been put, and the producers would
+	// overwrite them before they are read
+	published, _ := this.barrier.Min()
+
+	if seq > atomic.LoadInt64(&this.read) || seq > published {
+		return ErrInvalidSequence
 	}
 
-	seq, err = this.seq.Request(needed)
+	// Moving the sequence back would let Rewind return to slots already reused
+	committed, err := this.seq.Get()
 	if err != nil {
-		return 0, err
+		return err
+	}
+
+	if seq < committed {
+		return ErrInvalidSequence
+	}
+
+	if err := this.seq.Commit(seq); err != nil {
+		return err
 	}
-	//log.Printf("consumer: size = %d, needed = %d, seq = %d\n", size, needed, seq)
 
-	data, err := this.buffer.Get(seq + 1 - int64(needed))
+	this.buffer.waitStrategy.Signal()
+	return nil
+}
+
+func (this *consumer) ManualCommit() bool {
+	return this.manual
+}
+
+func (this *consumer) Rewind(seq int64) error {
+	committed, err := this.seq.Get()
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	//log.Printf("consumer: commit %d\n", seq)
-	this.seq.Commit(seq)
+	if seq < committed || seq > this.read {
+		return ErrInvalidSequence
+	}
+
+	atomic.StoreInt64(&this.read, seq)
+	this.pending = false
+
+	if this.buffer.auditor != nil {
+		this.buffer.auditor.AuditRewind(this.id, seq)
+	}
+
+	return nil
+}
 
-	return data, nil
+// release marks the entry ending at seq to be committed by the next call to next,
+// unless the consumer commits manually.
+func (this *consumer) release(seq int64) {
+	if !this.manual {
+		this.last = seq
+		this.pending = true
+	}
+}
+
+func (this *consumer) Close() error {
+	// Wake up a pending Get first, as release waits for it to return
+	if !this.reader.Close() {
+		return nil
+	}
+
+	this.buffer.removeConsumer(this)
+
+	// The producers may be waiting for the slots this consumer held
+	this.buffer.waitStrategy.Signal()
+
+	return this.buffer.release()
+}
+
+func (this *consumer) Interrupt() {
+	this.reader.Interrupt()
+}
+
+// next waits for the next entry and reads it, without committing it.
+func (this *consumer) next() (Entry, error) {
+	var e Entry
+
+	if this.reader.IsClosed() {
+		return e, ErrClosed
+	}
+
+	if this.pending {
+		//log.Printf("consumer: commit %d\n", this.last)
+		this.seq.Commit(this.last)
+		this.buffer.waitStrategy.Signal()
+		this.pending = false
+	}
+
+	var start, end, began int64
+
+	if this.buffer.auditor != nil {
+		began = Nanotime()
+	}
+
+	for {
+		start = this.read + 1
+
+		if err := this.wait(start); err != nil {
+			return e, err
+		}
+
+		// The producers don't wait for a lossy consumer, so the slot may be overwritten
+		// while we read it
+		if this.lossy && !this.buffer.intact(start, 1) {
+			this.skipAhead()
+			continue
+		}
+
+		size := this.buffer.NextDataSize(start)
+
+		if this.lossy && !this.buffer.intact(start, 1) {
+			this.skipAhead()
+			continue
+		}
+
+		if skipped := this.buffer.skipped(start); skipped >= 0 {
+			atomic.StoreInt64(&this.read, skipped)
+			continue
+		}
+
+		needed, err := this.buffer.SlotsNeeded(size)
+		if err != nil {
+			return e, err
+		}
+
+		// The producer commits all the slots of an entry at once, so this does not wait
+		end = start + int64(needed) - 1
+
+		if err := this.wait(end); err != nil {
+			return e, err
+		}
+		//log.Printf("consumer: size = %d, needed = %d, end = %d\n", size, needed, end)
+
+		if !this.lossy {
+			if err := this.buffer.GetEntry(start, &e, &this.tmpbuf); err != nil {
+				return e, err
+			}
+
+			break
+		}
+
+		if !this.buffer.intact(start, needed) {
+			this.skipAhead()
+			continue
+		}
+
+		if err := this.buffer.GetEntry(start, &e, &this.tmpbuf); err != nil {
+			return e, err
+		}
+
+		this.lbuf = append(this.lbuf[:0], e.Data...)
+		e.Data = this.lbuf
+
+		// The producers may have overwritten the entry while we were copying it
+		if !this.buffer.intact(start, needed) {
+			this.skipAhead()
+			continue
+		}
+
+		break
+	}
+
+	atomic.StoreInt64(&this.read, end)
+	e.Seq = end
+
+	if e.Flags&FlagCompressed != 0 && this.buffer.compressor != nil {
+		data, err := this.buffer.compressor.Decompress(this.zbuf, e.Data)
+		if err != nil {
+			return e, err
+		}
+
+		this.zbuf = data
+		e.Data = data
+		e.Flags &^= FlagCompressed
+	}
+
+	if this.buffer.auditor != nil {
+		this.buffer.auditor.AuditGet(this.id, e, began, Nanotime())
+	}
+
+	return e, nil
+}
+
+// skipAhead is called when the producers have overwritten the slots a lossy consumer was
+// about to read. The consumer skips past the latest entry, counting the slots skipped.
+func (this *consumer) skipAhead() {
+	published, _ := this.barrier.Min()
+
+	if published > this.read {
+		atomic.AddUint64(&this.stats.Lost, uint64(published-this.read))
+		atomic.StoreInt64(&this.read, published)
+	}
+}
+
+// wait blocks until seq has been committed by the producers. If the ring buffer is
+// closed and seq will never be committed, ErrClosed is returned.
+func (this *consumer) wait(seq int64) error {
+	switch err := this.reader.Wait(seq); err {
+	case sequence.ErrAlerted:
+		return ErrClosed
+
+	case sequence.ErrTimeout:
+		return ErrTimeout
+
+	default:
+		return err
+	}
 }
@@ -0,0 +1,497 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+import (
+	"bytes"
+	"github.com/reducedb/ringbuffer"
+	"github.com/reducedb/ringbuffer/sequence"
+	"log"
+	"runtime"
+	"testing"
+	"time"
+)
+
+var _ = log.Ldate
+
+func TestConsumerManualCommitAndRewind(t *testing.T) {
+	r, err := New(4, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.(RingBuffer).NewConsumerWithOptions(WithManualCommit())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := p.Put([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		if e, err := c.GetEntry(); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(e.Data, []byte{byte(i)}) {
+			t.Fatalf("Expect %d, got %v", i, e.Data)
+		}
+	}
+
+	// Nothing has been committed, so everything can be read again
+	if err := c.Rewind(-1); err != nil {
+		t.Fatal(err)
+	}
+
+	if e, err := c.GetEntry(); err != nil {
+		t.Fatal(err)
+	} else if e.Seq != 0 || !bytes.Equal(e.Data, []byte{0}) {
+		t.Fatalf("Expect entry 0, got %d: %v", e.Seq, e.Data)
+	}
+
+	// Entries can only be committed once they have been read
+	if err := c.Commit(1); err != ErrInvalidSequence {
+		t.Fatalf("Expect ErrInvalidSequence, got %v", err)
+	}
+
+	if e, err := c.GetEntry(); err != nil {
+		t.Fatal(err)
+	} else if e.Seq != 1 {
+		t.Fatalf("Expect entry 1, got %d", e.Seq)
+	}
+
+	if err := c.Commit(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Rewind(0); err != ErrInvalidSequence {
+		t.Fatalf("Expect ErrInvalidSequence, got %v", err)
+	}
+
+	// Committing again is fine, but the committed sequence never moves back
+	if err := c.Commit(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Commit(0); err != ErrInvalidSequence {
+		t.Fatalf("Expect ErrInvalidSequence, got %v", err)
+	}
+
+	if err := c.Rewind(0); err != ErrInvalidSequence {
+		t.Fatalf("Expect ErrInvalidSequence, got %v", err)
+	}
+
+	if e, err := c.GetEntry(); err != nil {
+		t.Fatal(err)
+	} else if e.Seq != 2 {
+		t.Fatalf("Expect entry 2, got %d", e.Seq)
+	}
+
+	if err := c.Rewind(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if e, err := c.GetEntry(); err != nil {
+		t.Fatal(err)
+	} else if e.Seq != 2 {
+		t.Fatalf("Expect entry 2, got %d", e.Seq)
+	}
+}
+
+func TestConsumerTimeout(t *testing.T) {
+	r, err := New(4, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.(RingBuffer).NewConsumerWithOptions(WithTimeout(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Get(); err != ErrTimeout {
+		t.Fatalf("Expect ErrTimeout, got %v", err)
+	}
+
+	if _, err := p.Put([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := c.Get(); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(out.([]byte), []byte{1}) {
+		t.Fatalf("Expect [1], got %v", out)
+	}
+}
+
+func TestConsumerCloseWakesGet(t *testing.T) {
+	r, err := New(4, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No producer yet, so there is nothing to read
+	c, err := r.NewConsumer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error, 1)
+
+	go func() {
+		_, err := c.Get()
+		errc <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+
+	select {
+	case err := <-errc:
+		t.Fatalf("Expect Get to wait for a producer, got %v", err)
+	default:
+	}
+
+	c.(Consumer).Close()
+
+	if err := <-errc; err != ErrClosed {
+		t.Fatalf("Expect ErrClosed, got %v", err)
+	}
+}
+
+func TestLossyConsumer(t *testing.T) {
+	r, err := New(4, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries put before the lossy consumer is created are not returned
+	if _, err := p.Put([]byte{100}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.(RingBuffer).NewConsumerWithOptions(WithLossy())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The producer laps the lossy consumer without waiting for it
+	for i := 0; i < 20; i++ {
+		if _, err := p.Put([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// It skips past the entries it missed, and waits for the next one
+	out := make(chan interface{}, 1)
+
+	go func() {
+		v, err := c.Get()
+		if err != nil {
+			v = err
+		}
+		out <- v
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := p.Put([]byte{20}); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := <-out; !bytes.Equal(v.([]byte), []byte{20}) {
+		t.Fatalf("Expect [20], got %v", v)
+	}
+
+	if stats := c.Stats(); stats.Lost != 20 {
+		t.Fatalf("Expect 20 lost, got %d", stats.Lost)
+	}
+}
+
+func TestStampsOnlyWithLossyConsumer(t *testing.T) {
+	r, err := New(8, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.NewConsumer(); err != nil {
+		t.Fatal(err)
+	}
+
+	stamps := r.(*byteBuffer).stamps
+
+	if _, err := p.Put([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if stamps[0] != -1 {
+		t.Fatalf("Expect slot 0 not to be stamped, got %d", stamps[0])
+	}
+
+	if _, err := r.(RingBuffer).NewConsumerWithOptions(WithLossy()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Put([]byte{2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if stamps[1] != 1 {
+		t.Fatalf("Expect slot 1 to be stamped with 1, got %d", stamps[1])
+	}
+}
+
+func TestLossyConsumerNeverTorn(t *testing.T) {
+	r, err := New(8, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.(RingBuffer).NewConsumerWithOptions(WithLossy())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const count = 5000
+
+	errc := make(chan error, 1)
+
+	// Each entry starts with its length, followed by its own byte repeated
+	go func() {
+		for i := 0; i < count; i++ {
+			data := bytes.Repeat([]byte{byte(i)}, 2+i%30)
+			data[0] = byte(len(data))
+
+			if _, err := p.Put(data); err != nil {
+				errc <- err
+				return
+			}
+
+			runtime.Gosched()
+		}
+
+		errc <- p.(Producer).Close()
+	}()
+
+	for {
+		data, err := c.Get()
+		if err == ErrClosed {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		b := data.([]byte)
+		if len(b) < 2 || len(b) != int(b[0]) || !bytes.Equal(b[1:], bytes.Repeat(b[1:2], len(b)-1)) {
+			t.Fatalf("Torn entry %v", b)
+		}
+	}
+
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	r, err := New(4, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.NewConsumer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lossy, err := r.(RingBuffer).NewConsumerWithOptions(WithLossy())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := p.Put([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := c.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := lossy.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := r.(RingBuffer).Status()
+
+	if s.SlotCount != 8 || s.SlotSize != 4+SlotOverhead || s.Closed {
+		t.Fatalf("Unexpected status %+v", s)
+	}
+
+	if len(s.Producers) != 1 || s.Producers[0] != 2 {
+		t.Fatalf("Expect producers == [2], got %v", s.Producers)
+	}
+
+	// The second entry is only committed by the next Get
+	if len(s.Consumers) != 2 || s.Consumers[0].Cursor != 0 || s.Consumers[0].Lag != 2 || s.Consumers[0].Lossy {
+		t.Fatalf("Unexpected consumer status %+v", s.Consumers)
+	}
+
+	// The lag of a lossy consumer counts from the last entry read
+	if !s.Consumers[1].Lossy || s.Consumers[1].Cursor != 0 || s.Consumers[1].Lag != 2 || s.Consumers[1].Stats.Entries != 1 {
+		t.Fatalf("Unexpected lossy consumer status %+v", s.Consumers[1])
+	}
+}
+
+func TestWaitStrategies(t *testing.T) {
+	strategies := map[string]ringbuffer.WaitStrategy{
+		"yield":    ringbuffer.NewYieldingWait(),
+		"sleep":    ringbuffer.NewSleepingWait(10, 10*time.Microsecond),
+		"blocking": ringbuffer.NewBlockingWait(time.Millisecond),
+	}
+
+	for name, w := range strategies {
+		r, err := New(4, 8, WithWaitStrategy(w))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := r.NewProducer()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := r.NewConsumer()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		errc := make(chan error, 1)
+
+		go func() {
+			for i := 0; i < 100; i++ {
+				if _, err := p.Put([]byte{byte(i), byte(i), byte(i)}); err != nil {
+					errc <- err
+					return
+				}
+			}
+
+			errc <- p.(Producer).Close()
+		}()
+
+		for i := 0; i < 100; i++ {
+			out, err := c.Get()
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			if !bytes.Equal(out.([]byte), []byte{byte(i), byte(i), byte(i)}) {
+				t.Fatalf("%s: bytes not the same", name)
+			}
+		}
+
+		if _, err := c.Get(); err != ErrClosed {
+			t.Fatalf("%s: expect ErrClosed, got %v", name, err)
+		}
+
+		if err := <-errc; err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
+
+// waitCounter counts the calls to WaitFor of the sequencer it wraps
+type waitCounter struct {
+	ringbuffer.Sequencer
+	waits int
+}
+
+func (this *waitCounter) WaitFor(seq int64) (int64, error) {
+	this.waits++
+	return this.Sequencer.WaitFor(seq)
+}
+
+func TestConsumerWaitsOncePerBatch(t *testing.T) {
+	r, err := New(4, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.NewConsumer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc := c.(*consumer)
+	counter := &waitCounter{Sequencer: cc.seq}
+	cc.reader = sequence.NewReader(counter, cc.barrier, sequence.InitialSequenceValue)
+
+	// Entries of 5 and 7 bytes take two slots each
+	for i := 0; i < 10; i++ {
+		if _, err := p.Put(bytes.Repeat([]byte{byte(i)}, 1+i%4*2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, err := c.Get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if counter.waits != 1 {
+		t.Fatalf("Expect 1 wait for the batch, got %d", counter.waits)
+	}
+
+	if _, err := p.Put([]byte{10}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	if counter.waits != 2 {
+		t.Fatalf("Expect 2 waits, got %d", counter.waits)
+	}
+}
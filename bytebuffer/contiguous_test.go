@@ -0,0 +1,170 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+var _ = log.Ldate
+
+func TestContiguousSkip(t *testing.T) {
+	r, err := New(4, 8, WithContiguous())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.NewConsumer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := r.(*byteBuffer)
+
+	// 3 entries of 2 slots each fill slots 0-5, then an entry of 3 slots would wrap, so
+	// slots 6 and 7 are skipped and it's put at slot 0 instead
+	for _, n := range []int{5, 5, 5, 14} {
+		if _, err := p.Put(bytes.Repeat([]byte{byte(n)}, n)); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := c.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(out.([]byte), bytes.Repeat([]byte{byte(n)}, n)) {
+			t.Fatalf("Expect %d bytes of %d, got %v", n, n, out)
+		}
+	}
+
+	if st.NextDataSize(6) != skipMarker {
+		t.Fatalf("Expect slot 6 to be skipped, got size %d", st.NextDataSize(6))
+	}
+
+	if cursor, _ := p.(*producer).seq.Get(); cursor != 10 {
+		t.Fatalf("Expect producer cursor == 10, got %d", cursor)
+	}
+}
+
+func TestContiguousZeroCopy(t *testing.T) {
+	r, err := New(4, 16, WithContiguous())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.NewConsumer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const count = 500
+
+	errc := make(chan error, 1)
+
+	go func() {
+		for i := 0; i < count; i++ {
+			if _, err := p.Put(bytes.Repeat([]byte{byte(i)}, i%40)); err != nil {
+				errc <- err
+				return
+			}
+		}
+
+		errc <- nil
+	}()
+
+	for i := 0; i < count; i++ {
+		out, err := c.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(out.([]byte), bytes.Repeat([]byte{byte(i)}, i%40)) {
+			t.Fatalf("Entry %d: bytes not the same", i)
+		}
+	}
+
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	// No entry wrapped, so none had to be copied
+	if c.(*consumer).tmpbuf != nil {
+		t.Fatalf("Expect no copies, got a %d bytes scratch buffer", len(c.(*consumer).tmpbuf))
+	}
+}
+
+func TestContiguousSnapshot(t *testing.T) {
+	r, err := New(4, 8, WithContiguous())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.(RingBuffer).NewConsumerWithOptions(WithManualCommit())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []int{5, 5, 5} {
+		if _, err := p.Put(bytes.Repeat([]byte{byte(n)}, n)); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := c.GetEntry(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Commit up to the 3rd entry and put one more, which is moved to slot 0
+	if err := c.Commit(5); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Put(bytes.Repeat([]byte{14}, 14)); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := r.(RingBuffer).WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := ReadSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.First != 6 || s.Last != 10 {
+		t.Fatalf("Expect first == 6 and last == 10, got %d, %d", s.First, s.Last)
+	}
+
+	e, err := s.Entry(6)
+	if err != ErrSkipped || e.Seq != 7 {
+		t.Fatalf("Expect slots 6-7 to be skipped, got %d, %v", e.Seq, err)
+	}
+
+	if e, err := s.Entry(8); err != nil {
+		t.Fatal(err)
+	} else if e.Seq != 10 || !bytes.Equal(e.Data, bytes.Repeat([]byte{14}, 14)) {
+		t.Fatalf("Unexpected entry %v", e)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+const (
+	// Number of bytes in the extended entry header, which follows the 2 bytes length
+	// prefix when the ring buffer is created with WithHeader(). The layout is
+	//
+	//   type (2) | flags (2) | reserved (2) | timestamp (8) | expires (8)
+	HeaderSize = 22
+
+	// The high byte of Flags is reserved for use by this package. Applications are free
+	// to use the low byte.
+	FlagUserMask = 0x00ff
+)
+
+// Entry is a single data item read from or written to the ring buffer together with
+// the extended header. Timestamp, Expires, Type and Flags are only meaningful if the ring
+// buffer was created with WithHeader().
+type Entry struct {
+	// Seq is the sequence of the last slot occupied by the entry
+	Seq int64
+
+	// Timestamp is the Nanotime() at which the entry was put into the buffer
+	Timestamp int64
+
+	// Expires is the Nanotime() after which consumers will skip the entry, or 0 if the
+	// entry never expires
+	Expires int64
+
+	Type  uint16
+	Flags uint16
+	Data  []byte
+}
+
+var epoch = time.Now()
+
+// Nanotime returns the number of nanoseconds elapsed since the package was initialized.
+// It is based on the monotonic clock, so it is suitable for measuring latency within
+// the process, but it is not comparable across processes.
+func Nanotime() int64 {
+	return int64(time.Since(epoch))
+}
+
+func putHeader(buf []byte, e *Entry) {
+	binary.LittleEndian.PutUint16(buf[0:2], e.Type)
+	binary.LittleEndian.PutUint16(buf[2:4], e.Flags)
+	binary.LittleEndian.PutUint64(buf[6:14], uint64(e.Timestamp))
+	binary.LittleEndian.PutUint64(buf[14:22], uint64(e.Expires))
+}
+
+func getHeader(buf []byte, e *Entry) {
+	e.Type = binary.LittleEndian.Uint16(buf[0:2])
+	e.Flags = binary.LittleEndian.Uint16(buf[2:4])
+	e.Timestamp = int64(binary.LittleEndian.Uint64(buf[6:14]))
+	e.Expires = int64(binary.LittleEndian.Uint64(buf[14:22]))
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+var _ = log.Ldate
+
+func TestErrHeaderDisabled(t *testing.T) {
+	r, err := New(4, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.(Producer).PutEntry(1, 0, []byte{1, 2, 3}); err != ErrHeaderDisabled {
+		t.Fatal("Should have exited with ErrHeaderDisabled")
+	}
+}
+
+func TestHeaderSlotSize(t *testing.T) {
+	b, err := New(4, 16, WithHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := b.(*byteBuffer)
+
+	if st.SlotSize() != 4+SlotOverhead+HeaderSize {
+		t.Fatalf("Expect slot size == %d, got %d", 4+SlotOverhead+HeaderSize, st.SlotSize())
+	}
+
+	if needed, err := st.SlotsNeeded(4); err != nil {
+		t.Fatal(err)
+	} else if needed != 1 {
+		t.Fatalf("Expect needed == 1, got %d", needed)
+	}
+
+	if needed, err := st.SlotsNeeded(5); err != nil {
+		t.Fatal(err)
+	} else if needed != 2 {
+		t.Fatalf("Expect needed == 2, got %d", needed)
+	}
+}
+
+func TestHeaderReadWrap(t *testing.T) {
+	b, err := New(4, 16, WithHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := b.(*byteBuffer)
+
+	data := []byte{1, 2, 3, 4, 5, 6}
+	if _, err := st.PutEntry(&Entry{Type: 7, Flags: 3, Timestamp: 100, Data: data}, 15); err != nil {
+		t.Fatal(err)
+	}
+
+	var e Entry
+	var scratch []byte
+
+	if err := st.GetEntry(15, &e, &scratch); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Type != 7 || e.Flags != 3 || e.Timestamp != 100 {
+		t.Fatalf("Expect type == 7, flags == 3, timestamp == 100, got %d, %d, %d", e.Type, e.Flags, e.Timestamp)
+	}
+
+	if !bytes.Equal(e.Data, data) {
+		t.Fatalf("bytes not the same")
+	}
+}
+
+func TestPutEntryKeepsEntry(t *testing.T) {
+	b, err := New(4, 16, WithHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := b.(*byteBuffer)
+
+	// The timestamp is set in the header only
+	in := &Entry{Type: 1, Data: []byte{1}}
+	if _, err := st.PutEntry(in, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if in.Timestamp != 0 {
+		t.Fatalf("Expect the entry to be left alone, got timestamp %d", in.Timestamp)
+	}
+
+	var e Entry
+	var scratch []byte
+
+	if err := st.GetEntry(0, &e, &scratch); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Timestamp == 0 {
+		t.Fatal("Expect a timestamp in the header")
+	}
+}
+
+func TestProducerAndConsumerEntry(t *testing.T) {
+	r, err := New(8, 16, WithHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.NewConsumer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+
+	for i := 0; i < 40; i++ {
+		before := Nanotime()
+
+		if _, err := p.(Producer).PutEntry(uint16(i), uint16(i%3), data[:i%len(data)]); err != nil {
+			t.Fatal(err)
+		}
+
+		e, err := c.(Consumer).GetEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e.Type != uint16(i) || e.Flags != uint16(i%3) {
+			t.Fatalf("Expect type == %d, flags == %d, got %d, %d", i, i%3, e.Type, e.Flags)
+		}
+
+		if e.Timestamp < before || e.Timestamp > Nanotime() {
+			t.Fatalf("Timestamp %d out of range", e.Timestamp)
+		}
+
+		if !bytes.Equal(e.Data, data[:i%len(data)]) {
+			t.Fatalf("bytes not the same")
+		}
+	}
+}
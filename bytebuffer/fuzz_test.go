@@ -0,0 +1,234 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+var _ = log.Ldate
+
+// fuzzMessages splits the fuzzer input into messages. Each message is a length byte
+// followed by that many bytes of data, scaled up so messages can span several slots.
+func fuzzMessages(in []byte, scale int) [][]byte {
+	var msgs [][]byte
+
+	for len(in) > 0 {
+		n := int(in[0]) * scale
+		in = in[1:]
+
+		msg := make([]byte, n)
+		for i := range msg {
+			if len(in) > 0 {
+				msg[i] = in[i%len(in)]
+			} else {
+				msg[i] = byte(i)
+			}
+		}
+
+		msgs = append(msgs, msg)
+
+		if len(in) > 0 {
+			in = in[1:]
+		}
+	}
+
+	return msgs
+}
+
+// fuzzRing creates a ring buffer from the fuzzed parameters, or returns nil if they are
+// out of range
+func fuzzRing(slotSize, slotCountLog uint8, header bool) *byteBuffer {
+	var opts []Option
+	if header {
+		opts = append(opts, WithHeader())
+	}
+
+	r, err := New(int(slotSize)+MinSlotSize, 1<<(slotCountLog%10), opts...)
+	if err != nil {
+		return nil
+	}
+
+	return r.(*byteBuffer)
+}
+
+func FuzzSlotsNeeded(f *testing.F) {
+	f.Add(uint8(2), uint8(4), false, uint32(0))
+	f.Add(uint8(2), uint8(4), true, uint32(5))
+	f.Add(uint8(0), uint8(9), false, uint32(MaxDataSize))
+	f.Add(uint8(255), uint8(0), true, uint32(1000))
+
+	f.Fuzz(func(t *testing.T, slotSize, slotCountLog uint8, header bool, size32 uint32) {
+		b := fuzzRing(slotSize, slotCountLog, header)
+		if b == nil {
+			return
+		}
+
+		size := int(size32)
+		needed, err := b.SlotsNeeded(size)
+
+		if size > MaxDataSize {
+			if err != ErrDataExceedsMaxSize {
+				t.Fatalf("Expect ErrDataExceedsMaxSize for size %d, got %v", size, err)
+			}
+			return
+		}
+
+		// Reference: the entry, including its overhead, rounded up to whole slots
+		expect := (size + b.overhead + b.slotSize - 1) / b.slotSize
+
+		if expect > b.slotCount {
+			if err != ErrDataExceedsMaxSlots {
+				t.Fatalf("Expect ErrDataExceedsMaxSlots for size %d, got %d, %v", size, needed, err)
+			}
+			return
+		}
+
+		if err != nil || needed != expect {
+			t.Fatalf("Expect %d slots for size %d (slot size %d), got %d, %v", expect, size, b.slotSize, needed, err)
+		}
+	})
+}
+
+func FuzzPutGet(f *testing.F) {
+	f.Add(uint8(2), uint8(4), false, []byte{4, 1, 7, 2, 3, 3})
+	f.Add(uint8(2), uint8(4), true, []byte{1, 9, 0, 0, 6, 1, 2, 3})
+	f.Add(uint8(6), uint8(2), false, []byte{10, 1, 2, 20, 3})
+	f.Add(uint8(0), uint8(3), true, []byte{200, 1, 2, 3, 4})
+
+	f.Fuzz(func(t *testing.T, slotSize, slotCountLog uint8, header bool, in []byte) {
+		b := fuzzRing(slotSize, slotCountLog, header)
+		if b == nil {
+			return
+		}
+
+		// The reference model remembers each message put and the slots it occupies
+		type entry struct {
+			start, end int64
+			typ        uint16
+			data       []byte
+		}
+
+		var entries []entry
+		var seq int64
+		var scratch []byte
+
+		for i, msg := range fuzzMessages(in, 1+int(slotSize)/4) {
+			needed, err := b.SlotsNeeded(len(msg))
+			if err != nil {
+				continue
+			}
+
+			e := &Entry{Type: uint16(i), Data: msg}
+
+			if n, err := b.PutEntry(e, seq); err != nil {
+				t.Fatal(err)
+			} else if n != needed {
+				t.Fatalf("Expect PutEntry to use %d slots, got %d", needed, n)
+			}
+
+			entries = append(entries, entry{seq, seq + int64(needed) - 1, uint16(i), msg})
+			seq += int64(needed)
+
+			// Every entry within the last lap must still read back intact
+			for j := len(entries) - 1; j >= 0 && entries[j].start > seq-1-int64(b.slotCount); j-- {
+				var out Entry
+
+				if err := b.GetEntry(entries[j].start, &out, &scratch); err != nil {
+					t.Fatal(err)
+				}
+
+				if !bytes.Equal(out.Data, entries[j].data) {
+					t.Fatalf("Entry at %d: expect %d bytes %v, got %d bytes %v", entries[j].start, len(entries[j].data), entries[j].data, len(out.Data), out.Data)
+				}
+
+				if header && out.Type != entries[j].typ {
+					t.Fatalf("Entry at %d: expect type %d, got %d", entries[j].start, entries[j].typ, out.Type)
+				}
+
+				if size := b.NextDataSize(entries[j].start); size != len(entries[j].data) {
+					t.Fatalf("Entry at %d: expect size %d, got %d", entries[j].start, len(entries[j].data), size)
+				}
+			}
+		}
+	})
+}
+
+func FuzzProducerConsumer(f *testing.F) {
+	f.Add(uint8(2), uint8(3), false, []byte{4, 1, 7, 2, 3, 3, 0, 9})
+	f.Add(uint8(14), uint8(2), true, []byte{40, 1, 0, 20, 3, 1, 60, 7})
+
+	f.Fuzz(func(t *testing.T, slotSize, slotCountLog uint8, header bool, in []byte) {
+		b := fuzzRing(slotSize, slotCountLog, header)
+		if b == nil {
+			return
+		}
+
+		p, err := b.NewProducer()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := b.NewConsumer()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The reference model is a FIFO queue of the messages put and not yet read, and
+		// the number of slots they occupy. The consumer commits lazily, so the last
+		// entry read still occupies its slots.
+		var queue [][]byte
+		var used, last int
+
+		get := func() {
+			out, err := c.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(out.([]byte), queue[0]) {
+				t.Fatalf("Expect %d bytes %v, got %d bytes %v", len(queue[0]), queue[0], len(out.([]byte)), out)
+			}
+
+			n, _ := b.SlotsNeeded(len(queue[0]))
+			used -= last
+			last = n
+			queue = queue[1:]
+		}
+
+		for i, msg := range fuzzMessages(in, 1+int(slotSize)/4) {
+			needed, err := b.SlotsNeeded(len(msg))
+			if err != nil {
+				continue
+			}
+
+			// Read until there is room, so the producer never blocks
+			for used+needed > b.slotCount && len(queue) > 0 {
+				get()
+			}
+
+			if used+needed > b.slotCount {
+				continue
+			}
+
+			if _, err := p.Put(msg); err != nil {
+				t.Fatal(err)
+			}
+
+			queue = append(queue, msg)
+			used += needed
+
+			// Read some of the entries along the way, depending on the input
+			if len(in) > 0 && in[i%len(in)]%3 == 0 {
+				get()
+			}
+		}
+
+		for len(queue) > 0 {
+			get()
+		}
+	})
+}
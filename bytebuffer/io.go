@@ -0,0 +1,151 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+import (
+	"github.com/reducedb/ringbuffer"
+	"io"
+	"log"
+)
+
+var _ = log.Ldate
+
+// Writer adapts a producer to io.Writer.
+type Writer struct {
+	producer  ringbuffer.Producer
+	chunkSize int
+}
+
+var _ io.Writer = (*Writer)(nil)
+
+// NewWriter returns a Writer where each Write becomes a single entry, so the message
+// boundaries are preserved. Writes larger than MaxDataSize return ErrDataExceedsMaxSize.
+func NewWriter(p ringbuffer.Producer) *Writer {
+	return &Writer{
+		producer: p,
+	}
+}
+
+// NewStreamWriter returns a Writer that treats the data as a stream and splits each
+// Write into entries of at most chunkSize bytes. Choosing the slot size of the buffer
+// as chunkSize makes each entry occupy a single slot.
+func NewStreamWriter(p ringbuffer.Producer, chunkSize int) *Writer {
+	if chunkSize > MaxDataSize {
+		chunkSize = MaxDataSize
+	}
+
+	return &Writer{
+		producer:  p,
+		chunkSize: chunkSize,
+	}
+}
+
+func (this *Writer) Write(b []byte) (int, error) {
+	if this.chunkSize <= 0 {
+		if _, err := this.producer.Put(b); err != nil {
+			return 0, err
+		}
+
+		return len(b), nil
+	}
+
+	n := 0
+
+	for n < len(b) {
+		end := n + this.chunkSize
+		if end > len(b) {
+			end = len(b)
+		}
+
+		if _, err := this.producer.Put(b[n:end]); err != nil {
+			return n, err
+		}
+
+		n = end
+	}
+
+	return n, nil
+}
+
+// Reader adapts a consumer to io.Reader and io.WriterTo. Once the ring buffer is closed
+// and all the entries have been read, io.EOF is returned.
+type Reader struct {
+	consumer Consumer
+
+	// rem holds the part of the current entry that didn't fit into the last Read
+	rem []byte
+	buf []byte
+}
+
+var _ io.Reader = (*Reader)(nil)
+var _ io.WriterTo = (*Reader)(nil)
+
+func NewReader(c Consumer) *Reader {
+	return &Reader{
+		consumer: c,
+	}
+}
+
+// Read copies the next entry into p. If p is too small, the remaining bytes of the entry
+// are returned by the following Reads, so at most one entry is returned by each Read.
+func (this *Reader) Read(p []byte) (int, error) {
+	if len(this.rem) > 0 {
+		n := copy(p, this.rem)
+		this.rem = this.rem[n:]
+		return n, nil
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	e, err := this.consumer.GetEntry()
+	if err == ErrClosed {
+		return 0, io.EOF
+	} else if err != nil {
+		return 0, err
+	}
+
+	n := copy(p, e.Data)
+
+	if n < len(e.Data) {
+		// Entry.Data is only valid until the next GetEntry, and points into the buffer
+		// that the producer may reuse, so keep our own copy of the remainder
+		this.buf = append(this.buf[:0], e.Data[n:]...)
+		this.rem = this.buf
+	}
+
+	return n, nil
+}
+
+// WriteTo writes all the entries to w until the ring buffer is closed.
+func (this *Reader) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+
+	if len(this.rem) > 0 {
+		n, err := w.Write(this.rem)
+		total += int64(n)
+		this.rem = this.rem[n:]
+
+		if err != nil {
+			return total, err
+		}
+	}
+
+	for {
+		e, err := this.consumer.GetEntry()
+		if err == ErrClosed {
+			return total, nil
+		} else if err != nil {
+			return total, err
+		}
+
+		n, err := w.Write(e.Data)
+		total += int64(n)
+
+		if err != nil {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"testing"
+)
+
+var _ = log.Ldate
+
+func TestWriterAndReader(t *testing.T) {
+	r, err := New(8, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.(RingBuffer).NewConsumerWithOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewWriter(p)
+
+	for _, msg := range []string{"hello", "ring buffer", "world"} {
+		if n, err := w.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		} else if n != len(msg) {
+			t.Fatalf("Expect n == %d, got %d", len(msg), n)
+		}
+	}
+
+	r.(RingBuffer).Close()
+
+	rd := NewReader(c)
+	buf := make([]byte, 5)
+
+	for _, msg := range []string{"hello", "ring ", "buffe", "r", "world"} {
+		n, err := rd.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(buf[:n]) != msg {
+			t.Fatalf("Expect %q, got %q", msg, buf[:n])
+		}
+	}
+
+	if _, err := rd.Read(buf); err != io.EOF {
+		t.Fatalf("Expect io.EOF, got %v", err)
+	}
+
+	if _, err := w.Write([]byte("late")); err != ErrClosed {
+		t.Fatalf("Expect ErrClosed, got %v", err)
+	}
+}
+
+func TestStreamWriterAndWriteTo(t *testing.T) {
+	r, err := New(16, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.(RingBuffer).NewConsumerWithOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	go func() {
+		w := NewStreamWriter(p, 16)
+		w.Write(data)
+		r.(RingBuffer).Close()
+	}()
+
+	var out bytes.Buffer
+
+	n, err := NewReader(c).WriteTo(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != int64(len(data)) || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("Expect %d bytes, got %d", len(data), n)
+	}
+
+	if stats := c.Stats(); stats.Entries != uint64((len(data)+15)/16) {
+		t.Fatalf("Expect %d entries, got %d", (len(data)+15)/16, stats.Entries)
+	}
+}
+
+func TestReadAllAfterClose(t *testing.T) {
+	r, err := New(8, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.(RingBuffer).NewConsumerWithOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.(RingBuffer).Close()
+
+	out, err := ioutil.ReadAll(NewReader(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out) != 0 {
+		t.Fatalf("Expect no data, got %d bytes", len(out))
+	}
+}
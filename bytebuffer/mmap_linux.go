@@ -0,0 +1,121 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+//go:build linux
+// +build linux
+
+package bytebuffer
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// memfd_create(2) is missing from the syscall package, and golang.org/x/sys/unix is not a
+// dependency, so its numbers are taken from the kernel's syscall tables. It's been there
+// since Linux 3.17. The architectures not listed, and the kernels without it, fall back
+// to a file in /dev/shm, so they need a tmpfs mounted there for WithDoubleMapping().
+var sysMemfdCreate = map[string]uintptr{
+	"386":     356,
+	"amd64":   319,
+	"arm":     385,
+	"arm64":   279,
+	"ppc64":   360,
+	"ppc64le": 360,
+	"riscv64": 279,
+	"s390x":   350,
+}
+
+// memfdArch selects the syscall number used by memfdCreate. The tests change it to
+// check the fallback.
+var memfdArch = runtime.GOARCH
+
+// memfd returns a file descriptor of an anonymous file of size bytes. If memfd_create(2)
+// is not available, it uses a file in /dev/shm that is removed right away.
+func memfd(size int) (int, error) {
+	fd, err := memfdCreate()
+	if err != nil {
+		if fd, err = shmCreate(); err != nil {
+			return -1, err
+		}
+	}
+
+	if err := syscall.Ftruncate(fd, int64(size)); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+
+	return fd, nil
+}
+
+// memfdCreate calls memfd_create(2), or returns syscall.ENOSYS if its number is unknown
+func memfdCreate() (int, error) {
+	trap, ok := sysMemfdCreate[memfdArch]
+	if !ok {
+		return -1, syscall.ENOSYS
+	}
+
+	name := []byte("ringbuffer\x00")
+
+	r, _, errno := syscall.Syscall(trap, uintptr(unsafe.Pointer(&name[0])), 0, 0)
+	if errno != 0 {
+		return -1, errno
+	}
+
+	return int(r), nil
+}
+
+// shmCreate returns a file descriptor of a file in /dev/shm, which is removed right away
+func shmCreate() (int, error) {
+	f, err := ioutil.TempFile("/dev/shm", "ringbuffer")
+	if err != nil {
+		return -1, err
+	}
+	defer f.Close()
+
+	os.Remove(f.Name())
+
+	return syscall.Dup(int(f.Fd()))
+}
+
+// doubleMap returns a slice of 2*size bytes whose second half maps the same memory as
+// the first half, so data written past the end of the first half appears at its start.
+// size must be a multiple of the page size.
+func doubleMap(size int) ([]byte, error) {
+	if size%os.Getpagesize() != 0 {
+		return nil, ErrNotPageAligned
+	}
+
+	fd, err := memfd(size)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+
+	// Reserve the address space for both halves, then map the file over each of them
+	mem, err := syscall.Mmap(-1, 0, 2*size, syscall.PROT_NONE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := uintptr(unsafe.Pointer(&mem[0]))
+
+	for _, half := range []uintptr{addr, addr + uintptr(size)} {
+		_, _, errno := syscall.Syscall6(syscall.SYS_MMAP, half, uintptr(size),
+			syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_FIXED, uintptr(fd), 0)
+		if errno != 0 {
+			syscall.Munmap(mem)
+			return nil, errno
+		}
+	}
+
+	return mem, nil
+}
+
+// unmap releases the memory returned by doubleMap
+func unmap(mem []byte) error {
+	return syscall.Munmap(mem)
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+//go:build linux
+// +build linux
+
+package bytebuffer
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+var _ = log.Ldate
+
+func TestDoubleMap(t *testing.T) {
+	size := os.Getpagesize()
+
+	mem, err := doubleMap(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unmap(mem)
+
+	if len(mem) != 2*size {
+		t.Fatalf("Expect %d bytes, got %d", 2*size, len(mem))
+	}
+
+	mem[1] = 42
+	mem[size+2] = 43
+
+	if mem[size+1] != 42 || mem[2] != 43 {
+		t.Fatal("Expect both halves to map the same memory")
+	}
+
+	if _, err := doubleMap(size + 1); err != ErrNotPageAligned {
+		t.Fatalf("Expect ErrNotPageAligned, got %v", err)
+	}
+}
+
+func TestMemfdFallback(t *testing.T) {
+	defer func(arch string) {
+		memfdArch = arch
+	}(memfdArch)
+
+	// An architecture whose memfd_create(2) number is unknown uses a file in /dev/shm
+	expected := map[string]string{
+		"unlisted": "/dev/shm/ringbuffer",
+	}
+
+	if _, ok := sysMemfdCreate[runtime.GOARCH]; ok {
+		expected[runtime.GOARCH] = "/memfd:ringbuffer"
+	}
+
+	for arch, prefix := range expected {
+		memfdArch = arch
+
+		fd, err := memfd(os.Getpagesize())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		link, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", fd))
+		syscall.Close(fd)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(link, prefix) {
+			t.Fatalf("Arch %s: expect a file starting with %s, got %s", arch, prefix, link)
+		}
+	}
+
+	memfdArch = "unlisted"
+	size := os.Getpagesize()
+
+	mem, err := doubleMap(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unmap(mem)
+
+	mem[size+1] = 42
+
+	if mem[1] != 42 {
+		t.Fatal("Expect both halves to map the same memory")
+	}
+}
+
+func TestDoubleMappingWrap(t *testing.T) {
+	if _, err := New(4, 16, WithDoubleMapping()); err != ErrNotPageAligned {
+		t.Fatalf("Expect ErrNotPageAligned, got %v", err)
+	}
+
+	slotSize := os.Getpagesize()/4 - SlotOverhead
+
+	r, err := New(slotSize, 4, WithDoubleMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.NewConsumer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries of 1.5 slots wrap around the end of the buffer every other lap
+	for i := 0; i < 20; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, slotSize*3/2)
+
+		if _, err := p.Put(data); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := c.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(out.([]byte), data) {
+			t.Fatalf("Entry %d: bytes not the same", i)
+		}
+	}
+
+	if c.(*consumer).tmpbuf != nil {
+		t.Fatalf("Expect no copies, got a %d bytes scratch buffer", len(c.(*consumer).tmpbuf))
+	}
+}
+
+func TestDoubleMappingRelease(t *testing.T) {
+	slotSize := os.Getpagesize()/4 - SlotOverhead
+
+	r, err := New(slotSize, 4, WithDoubleMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.NewConsumer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Put([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	r.(RingBuffer).Close()
+
+	// The consumer still reads the remaining entries from the mapping
+	if r.(*byteBuffer).unmapped {
+		t.Fatal("Expect the mapping to be kept until the consumer is closed")
+	}
+
+	out, err := c.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out.([]byte), []byte{1}) {
+		t.Fatalf("Expect [1], got %v", out)
+	}
+
+	if _, err := c.Get(); err != ErrClosed {
+		t.Fatalf("Expect ErrClosed, got %v", err)
+	}
+
+	if err := c.(Consumer).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.(*byteBuffer).unmapped {
+		t.Fatal("Expect the mapping to be released once the consumer is closed")
+	}
+
+	// Nothing touches the mapping anymore
+	if _, err := p.Put([]byte{2}); err != ErrClosed {
+		t.Fatalf("Expect ErrClosed, got %v", err)
+	}
+
+	if _, err := c.Get(); err != ErrClosed {
+		t.Fatalf("Expect ErrClosed, got %v", err)
+	}
+
+	if _, err := r.NewConsumer(); err != ErrClosed {
+		t.Fatalf("Expect ErrClosed, got %v", err)
+	}
+
+	if err := r.(*byteBuffer).WriteSnapshot(&bytes.Buffer{}); err != ErrClosed {
+		t.Fatalf("Expect ErrClosed, got %v", err)
+	}
+
+	// A ring buffer with no consumers releases the mapping when it's closed
+	r, err = New(slotSize, 4, WithDoubleMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.(RingBuffer).Close()
+
+	if !r.(*byteBuffer).unmapped {
+		t.Fatal("Expect the mapping to be released once the ring buffer is closed")
+	}
+}
@@ -0,0 +1,15 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+//go:build !linux
+// +build !linux
+
+package bytebuffer
+
+func doubleMap(size int) ([]byte, error) {
+	return nil, ErrDoubleMappingUnsupported
+}
+
+func unmap(mem []byte) error {
+	return nil
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+import (
+	"github.com/reducedb/ringbuffer"
+)
+
+// Option configures the ring buffer created by New.
+type Option func(*byteBuffer) error
+
+// WithHeader adds the extended entry header (timestamp, type and flags) to every entry.
+// Each entry will use HeaderSize more bytes of the slot.
+func WithHeader() Option {
+	return func(this *byteBuffer) error {
+		this.header = true
+		return nil
+	}
+}
+
+// WithCompression compresses the data of each entry with c when it makes the entry
+// smaller. Compressed entries are marked with FlagCompressed in the extended header,
+// so WithCompression enables WithHeader as well.
+func WithCompression(c Compressor) Option {
+	return func(this *byteBuffer) error {
+		this.header = true
+		this.compressor = c
+		return nil
+	}
+}
+
+// WithWaitStrategy sets what the producers and consumers do while they wait for each
+// other. The default is ringbuffer.NewYieldingWait().
+func WithWaitStrategy(w ringbuffer.WaitStrategy) Option {
+	return func(this *byteBuffer) error {
+		this.waitStrategy = w
+		return nil
+	}
+}
+
+// WithContiguous keeps the data of every entry contiguous in the buffer, so Get never has
+// to copy it. When an entry would wrap around the end of the buffer, the producer marks
+// the remaining slots as skipped and puts the entry at the start of the buffer instead.
+// Entries may then waste up to one less than the number of slots they need.
+func WithContiguous() Option {
+	return func(this *byteBuffer) error {
+		this.contiguous = true
+		return nil
+	}
+}
+
+// WithDoubleMapping maps the memory of the buffer twice, back to back, so that entries
+// wrapping around the end of the buffer are still contiguous and Get never copies them.
+// It's only supported on Linux, where it uses memfd_create(2), or a file in /dev/shm on
+// the architectures and kernels without it. The buffer size, i.e. the slot size including
+// the overhead times the slot count, must be a multiple of the page size. The mapping is
+// released once the ring buffer and all its consumers are closed, so the data returned
+// by Get must not be used after its consumer is closed.
+func WithDoubleMapping() Option {
+	return func(this *byteBuffer) error {
+		this.mapped = true
+		return nil
+	}
+}
@@ -4,19 +4,51 @@
 package bytebuffer
 
 import (
+	"fmt"
 	"github.com/reducedb/ringbuffer"
 	"github.com/reducedb/ringbuffer/sequence"
 	"log"
+	"time"
 )
 
 var _ = log.Ldate
 
+// Producer is implemented by the producers returned from NewProducer. It adds to
+// ringbuffer.Producer the ability to set the extended header of each entry.
+type Producer interface {
+	ringbuffer.Producer
+
+	// PutEntry writes data to the ring buffer with the type and flags in the extended
+	// header. ErrHeaderDisabled is returned if the buffer was not created WithHeader().
+	PutEntry(typ, flags uint16, data []byte) (int, error)
+
+	// PutEntryTTL is the same as PutEntry, but consumers will skip the entry if it's
+	// not consumed within ttl.
+	PutEntryTTL(typ, flags uint16, data []byte, ttl time.Duration) (int, error)
+
+	// PutEntryHeader is the same as PutEntry, but also keeps e.Timestamp and e.Expires,
+	// which are relative to Nanotime(), so entries can be copied from another ring
+	// buffer with their header. If e.Timestamp is 0, the current Nanotime() is used.
+	PutEntryHeader(e Entry) (int, error)
+
+	// Close marks the producer as done. Once all the producers are closed, the ring
+	// buffer is closed as well.
+	Close() error
+}
+
 type producer struct {
 	buffer *byteBuffer
 	seq    ringbuffer.Sequencer
+	closed bool
+
+	// id identifies the producer to the auditor
+	id string
+
+	// zbuf holds the compressed data of the current entry
+	zbuf []byte
 }
 
-var _ ringbuffer.Producer = (*producer)(nil)
+var _ Producer = (*producer)(nil)
 
 func (this *byteBuffer) NewProducer() (ringbuffer.Producer, error) {
 	this.mutex.Lock()
@@ -28,16 +60,24 @@ func (this *byteBuffer) NewProducer() (ringbuffer.Producer, error) {
 			return nil, err
 		}
 
+		seq.SetWaitStrategy(this.waitStrategy)
+
 		p := &producer{
 			buffer: this,
 			seq:    seq,
+			id:     fmt.Sprintf("p%d", this.producerIds),
 		}
 
+		this.producerIds++
+
 		this.producers = append(this.producers, p)
 
 		for _, c := range this.consumers {
 			c.seq.AddGatingSequence(p.seq)
-			p.seq.AddGatingSequence(c.seq)
+
+			if !c.lossy {
+				p.seq.AddGatingSequence(c.seq)
+			}
 		}
 
 		return p, nil
@@ -47,6 +87,23 @@ func (this *byteBuffer) NewProducer() (ringbuffer.Producer, error) {
 	return nil, ErrMaxProducerCountExceeded
 }
 
+func (this *producer) Close() error {
+	this.buffer.mutex.Lock()
+
+	this.closed = true
+
+	for _, p := range this.buffer.producers {
+		if !p.closed {
+			this.buffer.mutex.Unlock()
+			return nil
+		}
+	}
+
+	this.buffer.mutex.Unlock()
+
+	return this.buffer.Close()
+}
+
 // Put writes the data to ring buffer.
 // Returns the number of elements written. The unit of the element depends on
 // the storage engine used.
@@ -56,7 +113,73 @@ func (this *producer) Put(data interface{}) (int, error) {
 		return 0, err
 	}
 
-	needed, err := this.buffer.SlotsNeeded(len(src))
+	return this.put(&Entry{Data: src})
+}
+
+func (this *producer) PutEntry(typ, flags uint16, data []byte) (int, error) {
+	if !this.buffer.header {
+		return 0, ErrHeaderDisabled
+	}
+
+	return this.put(&Entry{Type: typ, Flags: flags & FlagUserMask, Data: data})
+}
+
+func (this *producer) PutEntryTTL(typ, flags uint16, data []byte, ttl time.Duration) (int, error) {
+	if !this.buffer.header {
+		return 0, ErrHeaderDisabled
+	}
+
+	now := Nanotime()
+
+	return this.put(&Entry{Type: typ, Flags: flags & FlagUserMask, Data: data, Timestamp: now, Expires: now + int64(ttl)})
+}
+
+func (this *producer) PutEntryHeader(e Entry) (int, error) {
+	if !this.buffer.header {
+		return 0, ErrHeaderDisabled
+	}
+
+	e.Flags &= FlagUserMask
+
+	return this.put(&e)
+}
+
+func (this *producer) put(e *Entry) (int, error) {
+	this.buffer.enter()
+	defer this.buffer.leave()
+
+	if this.buffer.isClosed() {
+		return 0, ErrClosed
+	}
+
+	var start int64
+
+	// The auditor sees the data before it's compressed
+	audit := *e
+
+	if this.buffer.auditor != nil {
+		start = Nanotime()
+	}
+
+	if this.buffer.compressor != nil && len(e.Data) >= MinCompressSize {
+		if len(e.Data) > MaxDataSize {
+			return 0, ErrDataExceedsMaxSize
+		}
+
+		zdata, err := this.buffer.compressor.Compress(this.zbuf, e.Data)
+		if err != nil {
+			return 0, err
+		}
+
+		this.zbuf = zdata
+
+		if len(zdata) < len(e.Data) {
+			e.Data = zdata
+			e.Flags |= FlagCompressed
+		}
+	}
+
+	needed, err := this.buffer.SlotsNeeded(len(e.Data))
 	//log.Printf("slots needed = %d\n", needed)
 	if err != nil {
 		return 0, err
@@ -64,20 +187,66 @@ func (this *producer) Put(data interface{}) (int, error) {
 
 	//log.Printf("slots needed = %d\n", needed)
 
+	if err := this.skip(needed); err != nil {
+		return 0, err
+	}
+
 	seq, err := this.seq.Request(needed)
-	if err != nil {
+	if err == sequence.ErrAlerted {
+		return 0, ErrClosed
+	} else if err != nil {
 		return 0, err
 	}
 
-	//log.Printf("slots needed = %d, seq = %d, data = %#v\n", needed, seq, src)
+	//log.Printf("slots needed = %d, seq = %d, data = %#v\n", needed, seq, e.Data)
 
-	n, err := this.buffer.Put(src, seq+1-int64(needed))
+	// Set here rather than by the buffer, so the auditor sees the timestamp written
+	if this.buffer.header && e.Timestamp == 0 {
+		e.Timestamp = Nanotime()
+	}
+
+	n, err := this.buffer.PutEntry(e, seq+1-int64(needed))
 	if err != nil {
 		return 0, err
 	}
 
 	//log.Printf("Producer: commit %d\n", seq)
 	this.seq.Commit(seq)
+	this.buffer.waitStrategy.Signal()
+
+	if this.buffer.auditor != nil {
+		audit.Seq = seq
+		audit.Timestamp = e.Timestamp
+		this.buffer.auditor.AuditPut(this.id, seq+1-int64(needed), audit, start, Nanotime())
+	}
 
 	return n, nil
 }
+
+// skip marks the slots up to the end of the buffer as skipped if an entry of needed slots
+// would wrap around. The skipped slots are committed on their own, so that the producer
+// never requests more slots than the buffer holds.
+func (this *producer) skip(needed int) error {
+	cursor, err := this.seq.Get()
+	if err != nil {
+		return err
+	}
+
+	pad := this.buffer.padding(cursor+1, needed)
+	if pad == 0 {
+		return nil
+	}
+
+	seq, err := this.seq.Request(pad)
+	if err == sequence.ErrAlerted {
+		return ErrClosed
+	} else if err != nil {
+		return err
+	}
+
+	this.buffer.putSkip(cursor + 1)
+	this.seq.Commit(seq)
+	this.buffer.waitStrategy.Signal()
+
+	return nil
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+import (
+	"log"
+)
+
+var _ = log.Ldate
+
+// Handler processes a single entry. Entry.Data is only valid during the call.
+type Handler func(Entry) error
+
+// Router dispatches entries to the handlers registered for their type, so a single ring
+// buffer can carry several kinds of events. Handlers must be registered before Run is
+// called.
+type Router struct {
+	handlers map[uint16]Handler
+	fallback Handler
+}
+
+func NewRouter() *Router {
+	return &Router{
+		handlers: make(map[uint16]Handler),
+	}
+}
+
+// Handle registers h for entries of type typ, replacing any previous handler.
+func (this *Router) Handle(typ uint16, h Handler) {
+	this.handlers[typ] = h
+}
+
+// HandleDefault registers h for entries without a type specific handler. If there's no
+// default handler, those entries are dropped.
+func (this *Router) HandleDefault(h Handler) {
+	this.fallback = h
+}
+
+// Filter returns true if there's a handler for the entry. It can be passed to WithFilter
+// so unhandled entries are skipped by the consumer.
+func (this *Router) Filter(e Entry) bool {
+	_, ok := this.handlers[e.Type]
+	return ok || this.fallback != nil
+}
+
+// Dispatch calls the handler registered for the type of e.
+func (this *Router) Dispatch(e Entry) error {
+	if h, ok := this.handlers[e.Type]; ok {
+		return h(e)
+	}
+
+	if this.fallback != nil {
+		return this.fallback(e)
+	}
+
+	return nil
+}
+
+// Run reads entries from c and dispatches them until either the consumer or a handler
+// returns an error, which is then returned.
+func (this *Router) Run(c Consumer) error {
+	for {
+		e, err := c.GetEntry()
+		if err != nil {
+			return err
+		}
+
+		if err := this.Dispatch(e); err != nil {
+			return err
+		}
+	}
+}
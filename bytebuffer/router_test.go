@@ -0,0 +1,117 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"testing"
+)
+
+var _ = log.Ldate
+
+func TestConsumerFilter(t *testing.T) {
+	r, err := New(8, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.(RingBuffer).NewConsumerWithOptions(WithFilter(func(e Entry) bool {
+		return len(e.Data) > 0 && e.Data[0]%2 == 0
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, err := p.Put([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 10; i += 2 {
+		out, err := c.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(out.([]byte), []byte{byte(i)}) {
+			t.Fatalf("Expect %d, got %v", i, out)
+		}
+	}
+
+	if stats := c.Stats(); stats.Filtered != 4 || stats.Entries != 5 {
+		t.Fatalf("Expect 4 filtered and 5 entries, got %d and %d", stats.Filtered, stats.Entries)
+	}
+}
+
+func TestWithTypesHeaderDisabled(t *testing.T) {
+	r, err := New(8, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.(RingBuffer).NewConsumerWithOptions(WithTypes(1)); err != ErrHeaderDisabled {
+		t.Fatal("Should have exited with ErrHeaderDisabled")
+	}
+}
+
+func TestRouter(t *testing.T) {
+	r, err := New(8, 64, WithHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter()
+
+	c, err := r.(RingBuffer).NewConsumerWithOptions(WithFilter(router.Filter))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ones, twos int
+	errDone := fmt.Errorf("done")
+
+	router.Handle(1, func(e Entry) error {
+		ones++
+		return nil
+	})
+
+	router.Handle(2, func(e Entry) error {
+		twos++
+		if twos == 3 {
+			return errDone
+		}
+		return nil
+	})
+
+	for i := 0; i < 9; i++ {
+		if _, err := p.(Producer).PutEntry(uint16(i%3), 0, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := router.Run(c); err != errDone {
+		t.Fatalf("Expect errDone, got %v", err)
+	}
+
+	if ones != 3 || twos != 3 {
+		t.Fatalf("Expect 3 ones and 3 twos, got %d and %d", ones, twos)
+	}
+
+	if stats := c.Stats(); stats.Filtered != 3 {
+		t.Fatalf("Expect 3 filtered, got %d", stats.Filtered)
+	}
+}
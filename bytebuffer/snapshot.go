@@ -0,0 +1,459 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/reducedb/ringbuffer"
+	"github.com/reducedb/ringbuffer/sequence"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// Number of bytes in the fixed part of the snapshot file header. The layout is
+	//
+	//   magic (4) | version (2) | flags (2) | slot size (4) | slot count (4) |
+	//   first (8) | last (8) | producer count (2) | consumer count (2) | entry count (4)
+	//
+	// followed by the producer and consumer cursors (8 bytes each), the checksums of the
+	// entries, and then the slots.
+	SnapshotHeaderSize = 40
+
+	SnapshotVersion = 1
+
+	snapshotMagic = "RBUF"
+
+	// Number of bytes of the checksum of an entry. The layout is
+	//
+	//   sequence (8) | slot count (4) | CRC-32 of the slots (4)
+	snapshotEntrySize = 16
+
+	snapshotFlagHeader     = 0x0001
+	snapshotFlagContiguous = 0x0002
+)
+
+var (
+	ErrSnapshotInvalid  = fmt.Errorf("bytebuffer: Invalid Snapshot")
+	ErrSnapshotChecksum = fmt.Errorf("bytebuffer: Snapshot Checksum Mismatch")
+	ErrSkipped          = fmt.Errorf("bytebuffer: Slots Skipped")
+)
+
+// Snapshot is a read-only copy of a ring buffer, as written by WriteSnapshot. Only the
+// entries from First to Last are copied; the rest of the slots are zero.
+type Snapshot struct {
+	SlotSize  int
+	SlotCount int
+	Header    bool
+
+	// Sequence of the first slot of the oldest intact entry. If First > Last, the snapshot
+	// holds no intact entries.
+	First int64
+
+	// Last sequence committed by all the producers
+	Last int64
+
+	// Last sequence committed by each producer and each consumer
+	Producers []int64
+	Consumers []int64
+
+	entries []snapshotEntry
+	buffer  *byteBuffer
+}
+
+// snapshotEntry is the checksum of the slots of an entry, or of a run of slots skipped
+// WithContiguous()
+type snapshotEntry struct {
+	seq      int64
+	slots    int
+	checksum uint32
+}
+
+// WriteSnapshot writes the slots and the cursors of the ring buffer to w, so it can be
+// inspected with OpenSnapshot or cmd/ringtool. It can be called while the producers are
+// running: only the entries committed by the producers and not yet released by the
+// consumers are copied, and the producers are held back from overwriting them until the
+// copy is done. A ring buffer with no consumers, or only lossy ones, doesn't hold back
+// its producers, so its snapshot never has intact entries.
+func (this *byteBuffer) WriteSnapshot(w io.Writer) error {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	if this.unmapped {
+		return ErrClosed
+	}
+
+	released := this.releasedLocked()
+
+	if released != math.MaxInt64 {
+		gate, err := sequence.NewConsumer(this.slotCount)
+		if err != nil {
+			return err
+		}
+
+		gate.Set(released)
+
+		for _, p := range this.producers {
+			p.seq.AddGatingSequence(gate)
+			defer p.seq.RemoveGatingSequence(gate)
+		}
+
+		// The consumers may have released more slots before the gate was added, which
+		// the producers may have overwritten since
+		released = this.releasedLocked()
+	}
+
+	last := this.publishedLocked()
+	first := last + 1
+
+	if released < first {
+		first = released + 1
+	}
+
+	buffer := make([]byte, this.bufferSize)
+
+	// The slots from first to last can't be written by the producers until the gate is
+	// removed, so they are copied without racing with them
+	if n := (last - first + 1) * int64(this.slotSize); n > 0 {
+		index := (first & int64(this.slotMask)) * int64(this.slotSize)
+
+		if l := int64(copy(buffer[index:index+min64(n, this.bufferSize-index)], this.buffer[index:])); l < n {
+			copy(buffer[:n-l], this.buffer)
+		}
+	}
+
+	// Each entry has its own checksum, so a damaged snapshot tells which entries are
+	// still good
+	view := &byteBuffer{
+		slotSize:   this.slotSize,
+		slotCount:  this.slotCount,
+		slotMask:   this.slotMask,
+		bufferSize: this.bufferSize,
+		overhead:   this.overhead,
+		contiguous: this.contiguous,
+		buffer:     buffer,
+	}
+
+	var entries []snapshotEntry
+
+	for seq := first; seq <= last; {
+		n := view.entrySlots(seq, last)
+		entries = append(entries, snapshotEntry{seq, n, view.slotsChecksum(seq, n)})
+		seq += int64(n)
+	}
+
+	consumers := make([]int64, 0, len(this.consumers))
+
+	for _, c := range this.consumers {
+		cursor, _ := c.seq.Get()
+		consumers = append(consumers, cursor)
+	}
+
+	producers := make([]int64, 0, len(this.producers))
+
+	for _, p := range this.producers {
+		cursor, _ := p.seq.Get()
+		producers = append(producers, cursor)
+	}
+
+	var flags uint16
+	if this.header {
+		flags |= snapshotFlagHeader
+	}
+
+	if this.contiguous {
+		flags |= snapshotFlagContiguous
+	}
+
+	hdr := make([]byte, SnapshotHeaderSize+8*(len(producers)+len(consumers))+snapshotEntrySize*len(entries))
+
+	copy(hdr[0:4], snapshotMagic)
+	binary.LittleEndian.PutUint16(hdr[4:6], SnapshotVersion)
+	binary.LittleEndian.PutUint16(hdr[6:8], flags)
+	binary.LittleEndian.PutUint32(hdr[8:12], uint32(this.slotSize))
+	binary.LittleEndian.PutUint32(hdr[12:16], uint32(this.slotCount))
+	binary.LittleEndian.PutUint64(hdr[16:24], uint64(first))
+	binary.LittleEndian.PutUint64(hdr[24:32], uint64(last))
+	binary.LittleEndian.PutUint16(hdr[32:34], uint16(len(producers)))
+	binary.LittleEndian.PutUint16(hdr[34:36], uint16(len(consumers)))
+	binary.LittleEndian.PutUint32(hdr[36:40], uint32(len(entries)))
+
+	i := SnapshotHeaderSize
+	for _, cursor := range append(producers, consumers...) {
+		binary.LittleEndian.PutUint64(hdr[i:i+8], uint64(cursor))
+		i += 8
+	}
+
+	for _, e := range entries {
+		binary.LittleEndian.PutUint64(hdr[i:i+8], uint64(e.seq))
+		binary.LittleEndian.PutUint32(hdr[i+8:i+12], uint32(e.slots))
+		binary.LittleEndian.PutUint32(hdr[i+12:i+16], e.checksum)
+		i += snapshotEntrySize
+	}
+
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+
+	_, err := w.Write(buffer)
+	return err
+}
+
+// releasedLocked returns the last sequence committed by all the consumers that hold back
+// the producers, or math.MaxInt64 if there are none.
+func (this *byteBuffer) releasedLocked() int64 {
+	released := int64(math.MaxInt64)
+
+	for _, c := range this.consumers {
+		if cursor, _ := c.seq.Get(); !c.lossy && cursor < released {
+			released = cursor
+		}
+	}
+
+	return released
+}
+
+// entrySlots returns the number of slots of the entry starting at seq, or of the run of
+// slots skipped from seq, without going past last. A size that can't be read as an entry
+// takes all the slots up to last.
+func (this *byteBuffer) entrySlots(seq, last int64) int {
+	end := last
+
+	if skipped := this.skipped(seq); skipped >= 0 {
+		end = skipped
+	} else if needed, err := this.SlotsNeeded(this.NextDataSize(seq)); err == nil {
+		end = seq + int64(needed) - 1
+	}
+
+	if end > last {
+		end = last
+	}
+
+	return int(end - seq + 1)
+}
+
+// slotsChecksum returns the CRC-32 of the n slots starting at seq, which may wrap around
+// the end of the buffer. It's only used on the copies of the slots, which are not
+// double mapped.
+func (this *byteBuffer) slotsChecksum(seq int64, n int) uint32 {
+	index := (seq & int64(this.slotMask)) * int64(this.slotSize)
+	end := index + int64(n*this.slotSize)
+
+	if end <= this.bufferSize {
+		return crc32.ChecksumIEEE(this.buffer[index:end])
+	}
+
+	crc := crc32.ChecksumIEEE(this.buffer[index:this.bufferSize])
+	return crc32.Update(crc, crc32.IEEETable, this.buffer[:end-this.bufferSize])
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// WriteSnapshotFile writes the snapshot of r to a temporary file and renames it to path,
+// so readers never see a partially written snapshot.
+func WriteSnapshotFile(r RingBuffer, path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+
+	if err := r.WriteSnapshot(w); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// ReadSnapshot reads a snapshot written by WriteSnapshot. It doesn't verify the checksums,
+// so a damaged snapshot can still be inspected; call Verify or Corrupt for that.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	hdr := make([]byte, SnapshotHeaderSize)
+
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, ErrSnapshotInvalid
+	}
+
+	if string(hdr[0:4]) != snapshotMagic || binary.LittleEndian.Uint16(hdr[4:6]) != SnapshotVersion {
+		return nil, ErrSnapshotInvalid
+	}
+
+	flags := binary.LittleEndian.Uint16(hdr[6:8])
+
+	s := &Snapshot{
+		SlotSize:  int(binary.LittleEndian.Uint32(hdr[8:12])),
+		SlotCount: int(binary.LittleEndian.Uint32(hdr[12:16])),
+		Header:    flags&snapshotFlagHeader != 0,
+		First:     int64(binary.LittleEndian.Uint64(hdr[16:24])),
+		Last:      int64(binary.LittleEndian.Uint64(hdr[24:32])),
+		Producers: make([]int64, binary.LittleEndian.Uint16(hdr[32:34])),
+		Consumers: make([]int64, binary.LittleEndian.Uint16(hdr[34:36])),
+	}
+
+	count := binary.LittleEndian.Uint32(hdr[36:40])
+
+	overhead := SlotOverhead
+	if s.Header {
+		overhead += HeaderSize
+	}
+
+	// Check the sizes before allocating the slots, the same way New does
+	if s.SlotSize < overhead+MinSlotSize || s.SlotSize > overhead+MaxDataSize {
+		return nil, ErrSnapshotInvalid
+	}
+
+	if s.SlotCount > MaxDataSlots || !ringbuffer.PowerOfTwo(s.SlotCount) {
+		return nil, ErrSnapshotInvalid
+	}
+
+	// Each entry takes at least one slot
+	if count > uint32(s.SlotCount) {
+		return nil, ErrSnapshotInvalid
+	}
+
+	cursors := make([]byte, 8*(len(s.Producers)+len(s.Consumers)))
+
+	if _, err := io.ReadFull(r, cursors); err != nil {
+		return nil, ErrSnapshotInvalid
+	}
+
+	for i := range s.Producers {
+		s.Producers[i] = int64(binary.LittleEndian.Uint64(cursors[i*8:]))
+	}
+
+	for i := range s.Consumers {
+		s.Consumers[i] = int64(binary.LittleEndian.Uint64(cursors[(len(s.Producers)+i)*8:]))
+	}
+
+	table := make([]byte, snapshotEntrySize*int(count))
+
+	if _, err := io.ReadFull(r, table); err != nil {
+		return nil, ErrSnapshotInvalid
+	}
+
+	s.entries = make([]snapshotEntry, count)
+
+	for i := range s.entries {
+		e := &s.entries[i]
+		e.seq = int64(binary.LittleEndian.Uint64(table[i*snapshotEntrySize:]))
+		e.slots = int(binary.LittleEndian.Uint32(table[i*snapshotEntrySize+8:]))
+		e.checksum = binary.LittleEndian.Uint32(table[i*snapshotEntrySize+12:])
+
+		if e.seq < s.First || e.slots < 1 || e.slots > s.SlotCount || e.seq+int64(e.slots)-1 > s.Last {
+			return nil, ErrSnapshotInvalid
+		}
+	}
+
+	s.buffer = &byteBuffer{
+		slotSize:   s.SlotSize,
+		slotCount:  s.SlotCount,
+		slotMask:   s.SlotCount - 1,
+		bufferSize: int64(s.SlotSize * s.SlotCount),
+		overhead:   overhead,
+		header:     s.Header,
+		contiguous: flags&snapshotFlagContiguous != 0,
+		buffer:     make([]byte, s.SlotSize*s.SlotCount),
+	}
+
+	if _, err := io.ReadFull(r, s.buffer.buffer); err != nil {
+		return nil, ErrSnapshotInvalid
+	}
+
+	return s, nil
+}
+
+// OpenSnapshot reads the snapshot file at path.
+func OpenSnapshot(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadSnapshot(bufio.NewReader(f))
+}
+
+// Verify returns ErrSnapshotChecksum if any entry is corrupt.
+func (this *Snapshot) Verify() error {
+	if len(this.Corrupt()) > 0 {
+		return ErrSnapshotChecksum
+	}
+
+	return nil
+}
+
+// Corrupt returns the first sequences of the entries whose slots don't match the
+// checksums written with the snapshot. A run of slots skipped WithContiguous() is
+// checked like an entry. The slots outside First and Last are not checked.
+func (this *Snapshot) Corrupt() []int64 {
+	var corrupt []int64
+
+	for _, e := range this.entries {
+		if this.buffer.slotsChecksum(e.seq, e.slots) != e.checksum {
+			corrupt = append(corrupt, e.seq)
+		}
+	}
+
+	return corrupt
+}
+
+// Entry returns the entry starting at slot seq. The next entry starts at e.Seq+1. The
+// data is not decompressed, and it is only valid until the next call to Entry.
+//
+// If the ring buffer was created WithContiguous() and the slots from seq were skipped,
+// ErrSkipped is returned with e.Seq set to the last slot skipped.
+func (this *Snapshot) Entry(seq int64) (Entry, error) {
+	var e Entry
+
+	if seq < this.First || seq > this.Last {
+		return e, ErrInvalidSequence
+	}
+
+	if skipped := this.buffer.skipped(seq); skipped >= 0 {
+		e.Seq = skipped
+		return e, ErrSkipped
+	}
+
+	needed, err := this.buffer.SlotsNeeded(this.buffer.NextDataSize(seq))
+	if err != nil {
+		return e, err
+	}
+
+	if seq+int64(needed)-1 > this.Last {
+		return e, ErrDataInvalid
+	}
+
+	if err := this.buffer.GetEntry(seq, &e, &this.buffer.tmpbuf); err != nil {
+		return e, err
+	}
+
+	e.Seq = seq + int64(needed) - 1
+	return e, nil
+}
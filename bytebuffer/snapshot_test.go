@@ -0,0 +1,238 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var _ = log.Ldate
+
+func TestSnapshot(t *testing.T) {
+	r, err := New(8, 16, WithHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.NewConsumer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+
+	// Wrap around a few times, leaving the last 4 entries unread
+	for i := 0; i < 20; i++ {
+		if _, err := p.(Producer).PutEntry(uint16(i), 0, data[:i%len(data)]); err != nil {
+			t.Fatal(err)
+		}
+
+		if i < 16 {
+			if _, err := c.(Consumer).GetEntry(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ring")
+
+	if err := WriteSnapshotFile(r.(RingBuffer), path); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.Header || s.SlotCount != 16 || len(s.Producers) != 1 || len(s.Consumers) != 1 {
+		t.Fatalf("Unexpected snapshot header %+v", s)
+	}
+
+	// The consumer has committed up to entry 14, since the commit of entry 15 is lazy
+	n := 0
+	for seq := s.First; seq <= s.Last; n++ {
+		e, err := s.Entry(seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		i := 15 + n
+		if e.Type != uint16(i) || !bytes.Equal(e.Data, data[:i%len(data)]) {
+			t.Fatalf("Expect entry %d, got type %d", i, e.Type)
+		}
+
+		seq = e.Seq + 1
+	}
+
+	if n != 5 {
+		t.Fatalf("Expect 5 entries, got %d", n)
+	}
+
+	if corrupt := s.Corrupt(); len(corrupt) != 0 {
+		t.Fatalf("Expect no corrupt entries, got %v", corrupt)
+	}
+
+	// Damage the data of the 2nd entry, which is the only one reported
+	e, err := s.Entry(s.First)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := e.Seq + 1
+	s.buffer.buffer[(second&int64(s.buffer.slotMask))*int64(s.SlotSize)+int64(s.buffer.overhead)] ^= 0xff
+
+	if err := s.Verify(); err != ErrSnapshotChecksum {
+		t.Fatalf("Expect ErrSnapshotChecksum, got %v", err)
+	}
+
+	if corrupt := s.Corrupt(); len(corrupt) != 1 || corrupt[0] != second {
+		t.Fatalf("Expect entry %d to be corrupt, got %v", second, corrupt)
+	}
+
+	// The slots outside the entries are not checked
+	s.buffer.buffer[((s.Last+1)&int64(s.buffer.slotMask))*int64(s.SlotSize)] ^= 0xff
+
+	if corrupt := s.Corrupt(); len(corrupt) != 1 {
+		t.Fatalf("Expect 1 corrupt entry, got %v", corrupt)
+	}
+
+	if _, err := ReadSnapshot(bytes.NewReader([]byte("garbage"))); err != ErrSnapshotInvalid {
+		t.Fatalf("Expect ErrSnapshotInvalid, got %v", err)
+	}
+}
+
+func TestSnapshotWhileRunning(t *testing.T) {
+	r, err := New(8, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.NewConsumer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const count = 2000
+
+	errc := make(chan error, 2)
+
+	// Each entry repeats its own byte, so a torn copy would show
+	go func() {
+		for i := 0; i < count; i++ {
+			if _, err := p.Put(bytes.Repeat([]byte{byte(i)}, 1+i%20)); err != nil {
+				errc <- err
+				return
+			}
+		}
+
+		errc <- p.(Producer).Close()
+	}()
+
+	go func() {
+		for {
+			if _, err := c.Get(); err == ErrClosed {
+				errc <- nil
+				return
+			} else if err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		var buf bytes.Buffer
+
+		if err := r.(RingBuffer).WriteSnapshot(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := ReadSnapshot(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for seq := s.First; seq <= s.Last; {
+			e, err := s.Entry(seq)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(e.Data) == 0 || !bytes.Equal(e.Data, bytes.Repeat(e.Data[:1], len(e.Data))) {
+				t.Fatalf("Torn entry %v", e.Data)
+			}
+
+			seq = e.Seq + 1
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadSnapshotSizes(t *testing.T) {
+	r, err := New(8, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := r.(RingBuffer).WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	// The header is checked before the slots are allocated
+	for _, sizes := range [][2]uint32{{1, 16}, {MaxDataSize + SlotOverhead + 1, 16}, {10, 0}, {10, 12}, {10, 1 << 31}} {
+		hdr := append([]byte(nil), buf.Bytes()...)
+		binary.LittleEndian.PutUint32(hdr[8:12], sizes[0])
+		binary.LittleEndian.PutUint32(hdr[12:16], sizes[1])
+
+		if _, err := ReadSnapshot(bytes.NewReader(hdr)); err != ErrSnapshotInvalid {
+			t.Fatalf("Slot size %d, count %d: expect ErrSnapshotInvalid, got %v", sizes[0], sizes[1], err)
+		}
+	}
+
+	// So is the number of entries
+	hdr := append([]byte(nil), buf.Bytes()...)
+	binary.LittleEndian.PutUint32(hdr[36:40], 17)
+
+	if _, err := ReadSnapshot(bytes.NewReader(hdr)); err != ErrSnapshotInvalid {
+		t.Fatalf("Expect ErrSnapshotInvalid, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package bytebuffer
+
+import (
+	"bytes"
+	"log"
+	"testing"
+	"time"
+)
+
+var _ = log.Ldate
+
+func TestExpiredEntriesSkipped(t *testing.T) {
+	r, err := New(8, 16, WithHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expired []uint16
+
+	c, err := r.(RingBuffer).NewConsumerWithOptions(WithExpiryHandler(func(e Entry) {
+		expired = append(expired, e.Type)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pp := p.(Producer)
+
+	if _, err := pp.PutEntryTTL(1, 0, []byte{1}, time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pp.PutEntryTTL(2, 0, []byte{2}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pp.PutEntryTTL(3, 0, []byte{3}, time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pp.PutEntry(4, 0, []byte{4}); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(time.Millisecond)
+
+	for _, typ := range []uint16{2, 4} {
+		e, err := c.GetEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if e.Type != typ || !bytes.Equal(e.Data, []byte{byte(typ)}) {
+			t.Fatalf("Expect type == %d, got %d", typ, e.Type)
+		}
+	}
+
+	if len(expired) != 2 || expired[0] != 1 || expired[1] != 3 {
+		t.Fatalf("Expect expired == [1 3], got %v", expired)
+	}
+
+	stats := c.Stats()
+	if stats.Entries != 2 || stats.Expired != 2 {
+		t.Fatalf("Expect 2 entries and 2 expired, got %d and %d", stats.Entries, stats.Expired)
+	}
+}
+
+func TestPutEntryTTLHeaderDisabled(t *testing.T) {
+	r, err := New(4, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.(Producer).PutEntryTTL(1, 0, []byte{1}, time.Second); err != ErrHeaderDisabled {
+		t.Fatal("Should have exited with ErrHeaderDisabled")
+	}
+}
@@ -0,0 +1,406 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+// Package check records the history of the entries put into and read from a ring
+// buffer, and verifies that the history is valid:
+//
+//   - no loss: a consumer reads every entry between the first and the last entry it
+//     read, unless it's lossy
+//   - no duplication: a consumer reads each entry once, unless it rewinds
+//   - FIFO: a consumer reads the entries of each producer in the order they were put
+//   - integrity: a consumer reads the same data that was put
+//   - linearizability: an entry is not read before it starts being put, nor after one
+//     of the slots it occupied has been overwritten by a put that completed a lap later
+//
+// A Recorder can be filled by hand from tests, or passed to bytebuffer.WithAuditor to
+// audit a ring buffer as it runs.
+package check
+
+import (
+	"fmt"
+	"github.com/reducedb/ringbuffer/bytebuffer"
+	"hash/fnv"
+	"log"
+	"sort"
+	"sync"
+)
+
+var _ = log.Ldate
+
+// Property is a property of the history that can be violated
+type Property int
+
+const (
+	Loss Property = iota
+	Duplicate
+	Order
+	Corrupt
+	Unknown
+	ReadBeforePut
+	Stale
+)
+
+func (this Property) String() string {
+	switch this {
+	case Loss:
+		return "loss"
+	case Duplicate:
+		return "duplicate"
+	case Order:
+		return "order"
+	case Corrupt:
+		return "corrupt"
+	case Unknown:
+		return "unknown"
+	case ReadBeforePut:
+		return "read before put"
+	case Stale:
+		return "stale"
+	}
+
+	return fmt.Sprintf("Property(%d)", int(this))
+}
+
+// Violation is a single read that violates a property of the history
+type Violation struct {
+	Property Property
+	Consumer string
+	Seq      int64
+	Msg      string
+}
+
+func (this Violation) Error() string {
+	return fmt.Sprintf("check: %s: consumer %s, seq %d: %s", this.Property, this.Consumer, this.Seq, this.Msg)
+}
+
+// op is a single put or read. first and seq are the first and last slots of the entry;
+// reads only know the last one.
+type op struct {
+	client     string
+	first, seq int64
+	size       int
+	hash       uint64
+	start, end int64
+
+	// Index of the put among the puts of the same producer
+	index int
+
+	// rewind marks a rewind to after seq instead of a read
+	rewind bool
+}
+
+// reader is what the recorder keeps about a consumer
+type reader struct {
+	lossy bool
+
+	// The reads and rewinds not verified yet, and the number recorded so far
+	reads []op
+	count int
+
+	// The last read, and the index of the last put read of each producer, since the
+	// last rewind
+	last      *op
+	producers map[string]int
+}
+
+// Recorder records the puts and reads of a ring buffer. It is safe for concurrent use.
+//
+// The recorder only keeps the last few laps of the history, so it can audit a ring buffer
+// for as long as it runs. The reads are verified once a lap of puts has been recorded
+// after them, and the puts more than two laps old are then dropped. A read recorded after
+// its entry was dropped is reported as Stale, since its slots have been overwritten.
+type Recorder struct {
+	slotCount int64
+
+	mutex  sync.Mutex
+	puts   map[int64]*op
+	counts map[string]int
+	reads  map[string]*reader
+
+	// high is the last slot of the latest put. The puts up to dropped are gone.
+	high    int64
+	dropped int64
+
+	violations []Violation
+}
+
+var _ bytebuffer.Auditor = (*Recorder)(nil)
+
+// NewRecorder returns a recorder for a ring buffer with slotCount slots, which is used to
+// detect reads of overwritten slots.
+func NewRecorder(slotCount int) *Recorder {
+	return &Recorder{
+		slotCount: int64(slotCount),
+		puts:      make(map[int64]*op),
+		counts:    make(map[string]int),
+		reads:     make(map[string]*reader),
+		high:      -1,
+		dropped:   -1,
+	}
+}
+
+func hash(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64()
+}
+
+// Put records that the producer put data in the slots first to seq between the times
+// start and end.
+func (this *Recorder) Put(producer string, first, seq int64, data []byte, start, end int64) {
+	h := hash(data)
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.puts[seq] = &op{
+		client: producer,
+		first:  first,
+		seq:    seq,
+		size:   len(data),
+		hash:   h,
+		start:  start,
+		end:    end,
+		index:  this.counts[producer],
+	}
+
+	this.counts[producer]++
+
+	if seq <= this.high {
+		return
+	}
+
+	this.high = seq
+
+	// Verify and drop in batches of a lap, so the puts are only sorted once per lap
+	if this.high-this.dropped < 3*this.slotCount {
+		return
+	}
+
+	this.verifyLocked(this.high - this.slotCount)
+	this.dropped = this.high - 2*this.slotCount
+
+	for s := range this.puts {
+		if s <= this.dropped {
+			delete(this.puts, s)
+		}
+	}
+}
+
+// Get records that the consumer read data at seq, the last slot of the entry, between
+// the times start and end.
+func (this *Recorder) Get(consumer string, seq int64, data []byte, start, end int64) {
+	h := hash(data)
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.readerLocked(consumer).add(op{
+		client: consumer,
+		seq:    seq,
+		size:   len(data),
+		hash:   h,
+		start:  start,
+		end:    end,
+	})
+}
+
+// Rewind records that the consumer will read the entries after seq again.
+func (this *Recorder) Rewind(consumer string, seq int64) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.readerLocked(consumer).add(op{
+		client: consumer,
+		seq:    seq,
+		rewind: true,
+	})
+}
+
+// Lossy records that the consumer may skip entries, so it's not checked for loss.
+func (this *Recorder) Lossy(consumer string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.readerLocked(consumer).lossy = true
+}
+
+func (this *Recorder) readerLocked(consumer string) *reader {
+	r, ok := this.reads[consumer]
+	if !ok {
+		r = &reader{producers: make(map[string]int)}
+		this.reads[consumer] = r
+	}
+
+	return r
+}
+
+func (this *reader) add(r op) {
+	this.reads = append(this.reads, r)
+	this.count++
+}
+
+func (this *Recorder) AuditConsumer(consumer string, lossy bool) {
+	if lossy {
+		this.Lossy(consumer)
+	}
+}
+
+func (this *Recorder) AuditPut(producer string, first int64, e bytebuffer.Entry, start, end int64) {
+	this.Put(producer, first, e.Seq, e.Data, start, end)
+}
+
+func (this *Recorder) AuditGet(consumer string, e bytebuffer.Entry, start, end int64) {
+	this.Get(consumer, e.Seq, e.Data, start, end)
+}
+
+func (this *Recorder) AuditRewind(consumer string, seq int64) {
+	this.Rewind(consumer, seq)
+}
+
+// Verify checks the reads recorded so far, and returns all the violations found, ordered
+// by the time they were verified, and then by consumer and read. The reads of entries
+// whose puts have not been recorded yet are reported as Unknown, so Verify is meant to be
+// called once the producers and consumers are done.
+func (this *Recorder) Verify() []Violation {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.verifyLocked(int64(^uint64(0) >> 1))
+
+	return append([]Violation(nil), this.violations...)
+}
+
+// Err returns the first violation found by Verify, or nil
+func (this *Recorder) Err() error {
+	if violations := this.Verify(); len(violations) > 0 {
+		return violations[0]
+	}
+
+	return nil
+}
+
+// timeline holds the puts ordered by seq, and for each of them the earliest time at
+// which it or a later put had completed, to find reads of overwritten slots.
+type timeline struct {
+	puts  []*op
+	ended []int64
+}
+
+func newTimeline(puts map[int64]*op) *timeline {
+	t := &timeline{
+		puts:  make([]*op, 0, len(puts)),
+		ended: make([]int64, len(puts)+1),
+	}
+
+	for _, p := range puts {
+		t.puts = append(t.puts, p)
+	}
+
+	sort.Slice(t.puts, func(i, j int) bool { return t.puts[i].seq < t.puts[j].seq })
+
+	t.ended[len(t.puts)] = int64(^uint64(0) >> 1)
+
+	for i := len(t.puts) - 1; i >= 0; i-- {
+		if t.ended[i] = t.ended[i+1]; t.puts[i].end < t.ended[i] {
+			t.ended[i] = t.puts[i].end
+		}
+	}
+
+	return t
+}
+
+// position returns the index in puts of the first put at or after seq
+func (this *timeline) position(seq int64) int {
+	return sort.Search(len(this.puts), func(i int) bool { return this.puts[i].seq >= seq })
+}
+
+// verifyLocked verifies the reads of each consumer in the order they were recorded, up
+// to the first one after seq.
+func (this *Recorder) verifyLocked(seq int64) {
+	t := newTimeline(this.puts)
+
+	consumers := make([]string, 0, len(this.reads))
+	for c := range this.reads {
+		consumers = append(consumers, c)
+	}
+
+	sort.Strings(consumers)
+
+	for _, c := range consumers {
+		r := this.reads[c]
+
+		n := 0
+		for ; n < len(r.reads) && r.reads[n].seq <= seq; n++ {
+			this.verifyRead(c, r, r.reads[n], t)
+		}
+
+		r.reads = append(r.reads[:0], r.reads[n:]...)
+	}
+}
+
+// verifyRead checks a single read or rewind of consumer c
+func (this *Recorder) verifyRead(c string, h *reader, r op, t *timeline) {
+	violate := func(p Property, seq int64, format string, args ...interface{}) {
+		this.violations = append(this.violations, Violation{
+			Property: p,
+			Consumer: c,
+			Seq:      seq,
+			Msg:      fmt.Sprintf(format, args...),
+		})
+	}
+
+	if r.rewind {
+		h.last = &op{seq: r.seq}
+		h.producers = make(map[string]int)
+		return
+	}
+
+	p, ok := this.puts[r.seq]
+	if !ok && r.seq <= this.dropped {
+		violate(Stale, r.seq, "read recorded after the puts up to seq %d were dropped", this.dropped)
+		h.last = &r
+		return
+	} else if !ok {
+		violate(Unknown, r.seq, "no entry was put at this seq")
+		return
+	}
+
+	if r.hash != p.hash || r.size != p.size {
+		violate(Corrupt, r.seq, "read %d bytes that differ from the %d bytes put", r.size, p.size)
+	}
+
+	if r.end < p.start {
+		violate(ReadBeforePut, r.seq, "read ended at %d, before the put started at %d", r.end, p.start)
+	}
+
+	// The puts from a lap after the first slot of the entry overwrite its slots
+	if i := t.position(p.first + this.slotCount); t.ended[i] < r.start {
+		violate(Stale, r.seq, "read started at %d, after a put at seq %d or later ended at %d", r.start, p.first+this.slotCount, t.ended[i])
+	}
+
+	if h.last != nil {
+		if r.seq <= h.last.seq {
+			if r.seq == h.last.seq {
+				violate(Duplicate, r.seq, "read twice")
+			} else {
+				violate(Duplicate, r.seq, "read again after seq %d without rewinding", h.last.seq)
+			}
+
+			h.last = &r
+			return
+		} else if !h.lossy {
+			// Every put between the last read and this one must have been read
+			if n := t.position(r.seq) - t.position(h.last.seq+1); n > 0 {
+				violate(Loss, r.seq, "%d entries after seq %d were not read", n, h.last.seq)
+			}
+		}
+	}
+
+	if index, ok := h.producers[p.client]; ok && p.index <= index {
+		violate(Order, r.seq, "read entry %d of producer %s after entry %d", p.index, p.client, index)
+	}
+
+	h.producers[p.client] = p.index
+	h.last = &r
+}
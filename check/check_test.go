@@ -0,0 +1,246 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package check
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/reducedb/ringbuffer/bytebuffer"
+	"log"
+	"testing"
+)
+
+var _ = log.Ldate
+
+func data(seq int64) []byte {
+	return []byte(fmt.Sprintf("entry %d", seq))
+}
+
+// history returns a recorder with 8 entries put by p0 and p1 into a ring buffer of 4 slots,
+// at times 10, 20, ... 80
+func history() *Recorder {
+	r := NewRecorder(4)
+
+	for seq := int64(0); seq < 8; seq++ {
+		r.Put(fmt.Sprintf("p%d", seq%2), seq, seq, data(seq), seq*10+10, seq*10+15)
+	}
+
+	return r
+}
+
+func TestVerify(t *testing.T) {
+	r := history()
+
+	// c0 reads everything, rewinds and reads the last 2 entries again
+	for seq := int64(0); seq < 8; seq++ {
+		r.Get("c0", seq, data(seq), seq*10+12, seq*10+20)
+	}
+
+	r.Rewind("c0", 5)
+	r.Get("c0", 6, data(6), 100, 101)
+	r.Get("c0", 7, data(7), 101, 102)
+
+	// c1 is lossy and skips entries
+	r.Lossy("c1")
+	r.Get("c1", 1, data(1), 20, 25)
+	r.Get("c1", 6, data(6), 70, 75)
+
+	if violations := r.Verify(); len(violations) != 0 {
+		t.Fatalf("Expect no violations, got %v", violations)
+	}
+}
+
+func TestViolations(t *testing.T) {
+	tests := []struct {
+		property Property
+		gets     func(r *Recorder)
+	}{
+		{Loss, func(r *Recorder) {
+			r.Get("c0", 0, data(0), 20, 21)
+			r.Get("c0", 2, data(2), 40, 41)
+		}},
+		{Duplicate, func(r *Recorder) {
+			r.Get("c0", 0, data(0), 20, 21)
+			r.Get("c0", 0, data(0), 22, 23)
+		}},
+		{Duplicate, func(r *Recorder) {
+			r.Get("c0", 1, data(1), 30, 31)
+			r.Get("c0", 0, data(0), 32, 33)
+		}},
+		{Corrupt, func(r *Recorder) {
+			r.Get("c0", 0, data(1), 20, 21)
+		}},
+		{Unknown, func(r *Recorder) {
+			r.Get("c0", 8, data(8), 100, 101)
+		}},
+		{ReadBeforePut, func(r *Recorder) {
+			r.Get("c0", 3, data(3), 0, 1)
+		}},
+		{Stale, func(r *Recorder) {
+			// Seq 4 overwrote slot 0 at time 55
+			r.Get("c0", 0, data(0), 60, 61)
+		}},
+	}
+
+	for _, test := range tests {
+		r := history()
+		test.gets(r)
+
+		violations := r.Verify()
+
+		if len(violations) != 1 || violations[0].Property != test.property {
+			t.Fatalf("Expect a %s violation, got %v", test.property, violations)
+		}
+	}
+}
+
+func TestStaleSlot(t *testing.T) {
+	r := NewRecorder(4)
+
+	// The entry at seq 1 occupies slots 0 and 1, and the entry at seq 4 overwrites slot 0
+	r.Put("p0", 0, 1, data(1), 10, 15)
+	r.Put("p0", 2, 3, data(3), 20, 25)
+	r.Put("p0", 4, 4, data(4), 30, 35)
+
+	r.Get("c0", 1, data(1), 40, 41)
+
+	if violations := r.Verify(); len(violations) != 1 || violations[0].Property != Stale {
+		t.Fatalf("Expect a stale violation, got %v", violations)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	const slotCount = 8
+
+	r := NewRecorder(slotCount)
+
+	for seq := int64(0); seq < 1000; seq++ {
+		r.Put("p0", seq, seq, data(seq), seq*10, seq*10+5)
+		r.Get("c0", seq, data(seq), seq*10+6, seq*10+7)
+
+		if n := len(r.puts); n > 3*slotCount {
+			t.Fatalf("Expect at most %d puts kept, got %d", 3*slotCount, n)
+		}
+
+		if n := len(r.reads["c0"].reads); n > 3*slotCount {
+			t.Fatalf("Expect at most %d reads kept, got %d", 3*slotCount, n)
+		}
+	}
+
+	// A read recorded long after its entry was dropped
+	r.Get("c1", 10, data(10), 9990, 9991)
+
+	violations := r.Verify()
+
+	if len(violations) != 1 || violations[0].Property != Stale || violations[0].Consumer != "c1" {
+		t.Fatalf("Expect a stale violation for c1, got %v", violations)
+	}
+}
+
+func TestOrder(t *testing.T) {
+	r := NewRecorder(4)
+
+	// p0's second entry was committed at a lower seq than its first
+	r.Put("p0", 1, 1, data(1), 10, 11)
+	r.Put("p0", 0, 0, data(0), 12, 13)
+
+	r.Get("c0", 0, data(0), 20, 21)
+	r.Get("c0", 1, data(1), 22, 23)
+
+	if violations := r.Verify(); len(violations) != 1 || violations[0].Property != Order {
+		t.Fatalf("Expect an order violation, got %v", violations)
+	}
+}
+
+func TestAuditor(t *testing.T) {
+	const slotCount = 16
+	const count = 200
+
+	rec := NewRecorder(slotCount)
+
+	r, err := bytebuffer.New(16, slotCount, bytebuffer.WithAuditor(rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := r.(bytebuffer.RingBuffer).NewConsumerWithOptions(bytebuffer.WithManualCommit())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lossy, err := r.(bytebuffer.RingBuffer).NewConsumerWithOptions(bytebuffer.WithLossy())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error, 2)
+
+	go func() {
+		for i := 0; i < count; i++ {
+			if _, err := p.Put(bytes.Repeat([]byte{byte(i)}, i%20)); err != nil {
+				errc <- err
+				return
+			}
+		}
+
+		errc <- p.(bytebuffer.Producer).Close()
+	}()
+
+	go func() {
+		for {
+			if _, err := lossy.GetEntry(); err == bytebuffer.ErrClosed {
+				errc <- nil
+				return
+			} else if err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+
+	prev := int64(-1)
+
+	for i := 0; i < count; i++ {
+		e, err := c.GetEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Read every 10th entry twice
+		if i%10 == 0 {
+			if err := c.Rewind(prev); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := c.GetEntry(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := c.Commit(e.Seq); err != nil {
+			t.Fatal(err)
+		}
+
+		prev = e.Seq
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := rec.reads["c0"].count; n != count+2*count/10 {
+		t.Fatalf("Expect %d reads and rewinds, got %d", count+2*count/10, n)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package main
+
+import (
+	"math/bits"
+)
+
+const (
+	// Values are recorded with subBucketBits significant bits, i.e. within 1/64 (~1.6%)
+	// of their actual value, like an HDR histogram with 2 significant digits
+	subBucketBits  = 7
+	subBucketCount = 1 << subBucketBits
+	subBucketHalf  = subBucketCount / 2
+
+	bucketCount = subBucketCount + (64-subBucketBits)*subBucketHalf
+)
+
+// histogram is a log-linear histogram of non-negative values, which records values in
+// constant time and space, and reports percentiles with bounded relative error.
+type histogram struct {
+	counts [bucketCount]uint64
+	count  uint64
+	sum    uint64
+	min    uint64
+	max    uint64
+}
+
+func newHistogram() *histogram {
+	return &histogram{}
+}
+
+// bucket returns the index of the bucket holding v
+func bucket(v uint64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+
+	shift := uint(bits.Len64(v) - subBucketBits)
+	top := v >> shift
+
+	return subBucketCount + int(shift-1)*subBucketHalf + int(top-subBucketHalf)
+}
+
+// highest returns the highest value recorded in bucket i
+func highest(i int) uint64 {
+	if i < subBucketCount {
+		return uint64(i)
+	}
+
+	i -= subBucketCount
+	shift := uint(i/subBucketHalf + 1)
+	top := uint64(i%subBucketHalf + subBucketHalf)
+
+	return (top+1)<<shift - 1
+}
+
+func (this *histogram) Record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+
+	u := uint64(v)
+
+	if this.count == 0 || u < this.min {
+		this.min = u
+	}
+
+	if u > this.max {
+		this.max = u
+	}
+
+	this.counts[bucket(u)]++
+	this.count++
+	this.sum += u
+}
+
+// Merge adds the values recorded by h
+func (this *histogram) Merge(h *histogram) {
+	if h.count == 0 {
+		return
+	}
+
+	if this.count == 0 || h.min < this.min {
+		this.min = h.min
+	}
+
+	if h.max > this.max {
+		this.max = h.max
+	}
+
+	for i, n := range h.counts {
+		this.counts[i] += n
+	}
+
+	this.count += h.count
+	this.sum += h.sum
+}
+
+func (this *histogram) Count() uint64 {
+	return this.count
+}
+
+func (this *histogram) Min() int64 {
+	return int64(this.min)
+}
+
+func (this *histogram) Max() int64 {
+	return int64(this.max)
+}
+
+func (this *histogram) Mean() float64 {
+	if this.count == 0 {
+		return 0
+	}
+
+	return float64(this.sum) / float64(this.count)
+}
+
+// Percentile returns the value below which q percent of the values fall
+func (this *histogram) Percentile(q float64) int64 {
+	if this.count == 0 {
+		return 0
+	}
+
+	target := uint64(q / 100 * float64(this.count))
+	if target == 0 {
+		target = 1
+	} else if target > this.count {
+		target = this.count
+	}
+
+	var total uint64
+
+	for i, n := range this.counts {
+		if total += n; total >= target {
+			if v := highest(i); v < this.max {
+				return int64(v)
+			}
+
+			break
+		}
+	}
+
+	return int64(this.max)
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package main
+
+import (
+	"log"
+	"math"
+	"testing"
+)
+
+var _ = log.Ldate
+
+func TestHistogramBuckets(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 129, 130, 1000, 123456789, math.MaxUint64} {
+		i := bucket(v)
+
+		if i < 0 || i >= bucketCount {
+			t.Fatalf("Bucket %d for %d out of range", i, v)
+		}
+
+		if h := highest(i); h < v || float64(h-v) > float64(v)/64 {
+			t.Fatalf("Expect highest(bucket(%d)) within 1/64 of %d, got %d", v, v, h)
+		}
+	}
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	h := newHistogram()
+
+	for i := int64(1); i <= 10000; i++ {
+		h.Record(i)
+	}
+
+	if h.Count() != 10000 || h.Min() != 1 || h.Max() != 10000 {
+		t.Fatalf("Expect count == 10000, min == 1, max == 10000, got %d, %d, %d", h.Count(), h.Min(), h.Max())
+	}
+
+	for _, q := range []float64{50, 90, 99, 99.9} {
+		expect := q * 100
+
+		if v := float64(h.Percentile(q)); v < expect || v > expect*(1+1.0/64) {
+			t.Fatalf("Expect p%v ~= %v, got %v", q, expect, v)
+		}
+	}
+
+	if h.Percentile(100) != 10000 {
+		t.Fatalf("Expect p100 == 10000, got %d", h.Percentile(100))
+	}
+
+	m := newHistogram()
+	m.Record(20000)
+	m.Merge(h)
+
+	if m.Count() != 10001 || m.Max() != 20000 || m.Min() != 1 {
+		t.Fatalf("Unexpected merged histogram count %d, min %d, max %d", m.Count(), m.Min(), m.Max())
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+// Ringbench measures the throughput and latency of a bytebuffer ring buffer, and of
+// buffered channels with the same topology as a baseline.
+//
+// Every consumer receives every message, so each message put by the producers is
+// delivered consumers times. The latency of a message is measured from right before it
+// is put until it is received by a consumer.
+//
+// The ring buffer only allows a single producer, so with more than one producer they
+// take turns using it behind a mutex. The channel baseline has no such restriction.
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"github.com/reducedb/ringbuffer"
+	"github.com/reducedb/ringbuffer/bytebuffer"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+var _ = log.Ldate
+
+var (
+	producers = flag.Int("producers", 1, "number of producers")
+	consumers = flag.Int("consumers", 1, "number of consumers")
+	count     = flag.Int("n", 1000000, "number of messages put by each producer")
+	size      = flag.Int("size", 64, "message size in bytes, at least 8")
+	slotSize  = flag.Int("slot-size", 128, "ring buffer slot size in bytes")
+	slotCount = flag.Int("slot-count", 1024, "number of ring buffer slots, a power of two")
+	wait      = flag.String("wait", "yield", "wait strategy: busy, yield, sleep or block")
+	chanSize  = flag.Int("chan-size", 0, "channel buffer size, defaults to slot-count")
+	baseline  = flag.Bool("chan", true, "also run the buffered channel baseline")
+)
+
+// result is the outcome of a single run
+type result struct {
+	name     string
+	elapsed  time.Duration
+	messages int
+	latency  *histogram
+}
+
+func (this *result) print() {
+	secs := this.elapsed.Seconds()
+
+	fmt.Printf("%s\n", this.name)
+	fmt.Printf("  %d msgs in %v, %.0f msgs/s, %.1f MB/s\n",
+		this.messages, this.elapsed, float64(this.messages)/secs,
+		float64(this.messages**size)/secs/(1024*1024))
+
+	h := this.latency
+
+	fmt.Printf("  latency mean=%v p50=%v p90=%v p99=%v p99.9=%v max=%v\n",
+		time.Duration(h.Mean()),
+		time.Duration(h.Percentile(50)),
+		time.Duration(h.Percentile(90)),
+		time.Duration(h.Percentile(99)),
+		time.Duration(h.Percentile(99.9)),
+		time.Duration(h.Max()))
+}
+
+func waitStrategy(name string) (ringbuffer.WaitStrategy, error) {
+	switch name {
+	case "busy":
+		return ringbuffer.NewBusySpinWait(), nil
+	case "yield":
+		return ringbuffer.NewYieldingWait(), nil
+	case "sleep":
+		return ringbuffer.NewSleepingWait(100, 50*time.Microsecond), nil
+	case "block":
+		return ringbuffer.NewBlockingWait(time.Millisecond), nil
+	}
+
+	return nil, fmt.Errorf("invalid wait strategy %q", name)
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("ringbench: ")
+
+	flag.Parse()
+
+	if *producers < 1 || *consumers < 1 || *count < 1 || *size < 8 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *size > bytebuffer.MaxDataSize {
+		log.Fatalf("size must be at most %d", bytebuffer.MaxDataSize)
+	}
+
+	if *chanSize == 0 {
+		*chanSize = *slotCount
+	}
+
+	w, err := waitStrategy(*wait)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("producers=%d consumers=%d n=%d size=%d slot-size=%d slot-count=%d wait=%s\n\n",
+		*producers, *consumers, *count, *size, *slotSize, *slotCount, *wait)
+
+	res, err := runRing(w)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	res.print()
+
+	if *baseline {
+		runChan().print()
+	}
+}
+
+// sizer is implemented by the bytebuffer ring buffers
+type sizer interface {
+	SlotsNeeded(size int) (int, error)
+}
+
+func runRing(w ringbuffer.WaitStrategy) (*result, error) {
+	r, err := bytebuffer.New(*slotSize, *slotCount, bytebuffer.WithWaitStrategy(w))
+	if err != nil {
+		return nil, err
+	}
+
+	// Fail now if the messages don't fit, rather than on the first Put
+	if _, err := r.(sizer).SlotsNeeded(*size); err != nil {
+		return nil, fmt.Errorf("size %d does not fit in %d slots of %d bytes: %v", *size, *slotCount, *slotSize, err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		return nil, err
+	}
+
+	cs := make([]ringbuffer.Consumer, *consumers)
+
+	for i := range cs {
+		if cs[i], err = r.NewConsumer(); err != nil {
+			return nil, err
+		}
+	}
+
+	total := *producers * *count
+	hists := make([]*histogram, *consumers)
+
+	var pwg, cwg sync.WaitGroup
+	var mutex sync.Mutex
+
+	// The first error closes the ring buffer, so the other producers and consumers stop
+	// instead of waiting forever
+	var once sync.Once
+	var firstErr error
+
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			r.(bytebuffer.RingBuffer).Close()
+		})
+	}
+
+	start := time.Now()
+
+	for i, c := range cs {
+		hists[i] = newHistogram()
+		cwg.Add(1)
+
+		go func(c ringbuffer.Consumer, h *histogram) {
+			defer cwg.Done()
+
+			for i := 0; i < total; i++ {
+				data, err := c.Get()
+				if err != nil {
+					fail(err)
+					return
+				}
+
+				h.Record(bytebuffer.Nanotime() - int64(binary.LittleEndian.Uint64(data.([]byte))))
+			}
+		}(c, hists[i])
+	}
+
+	for i := 0; i < *producers; i++ {
+		pwg.Add(1)
+
+		go func() {
+			defer pwg.Done()
+
+			data := make([]byte, *size)
+
+			for i := 0; i < *count; i++ {
+				mutex.Lock()
+				binary.LittleEndian.PutUint64(data, uint64(bytebuffer.Nanotime()))
+				_, err := p.Put(data)
+				mutex.Unlock()
+
+				if err != nil {
+					fail(err)
+					return
+				}
+			}
+		}()
+	}
+
+	pwg.Wait()
+	cwg.Wait()
+
+	elapsed := time.Since(start)
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	res := &result{
+		name:     fmt.Sprintf("ring buffer (wait=%s)", *wait),
+		elapsed:  elapsed,
+		messages: total,
+		latency:  newHistogram(),
+	}
+
+	for _, h := range hists {
+		res.latency.Merge(h)
+	}
+
+	return res, nil
+}
+
+func runChan() *result {
+	chans := make([]chan []byte, *consumers)
+	for i := range chans {
+		chans[i] = make(chan []byte, *chanSize)
+	}
+
+	total := *producers * *count
+	hists := make([]*histogram, *consumers)
+
+	var pwg, cwg sync.WaitGroup
+
+	start := time.Now()
+
+	for i, ch := range chans {
+		hists[i] = newHistogram()
+		cwg.Add(1)
+
+		go func(ch chan []byte, h *histogram) {
+			defer cwg.Done()
+
+			for i := 0; i < total; i++ {
+				data := <-ch
+				h.Record(bytebuffer.Nanotime() - int64(binary.LittleEndian.Uint64(data)))
+			}
+		}(ch, hists[i])
+	}
+
+	for i := 0; i < *producers; i++ {
+		pwg.Add(1)
+
+		go func() {
+			defer pwg.Done()
+
+			for i := 0; i < *count; i++ {
+				// Each message is copied once, like the ring buffer does, and shared by
+				// the consumers
+				data := make([]byte, *size)
+				binary.LittleEndian.PutUint64(data, uint64(bytebuffer.Nanotime()))
+
+				for _, ch := range chans {
+					ch <- data
+				}
+			}
+		}()
+	}
+
+	pwg.Wait()
+	cwg.Wait()
+
+	res := &result{
+		name:     fmt.Sprintf("channels (size=%d)", *chanSize),
+		elapsed:  time.Since(start),
+		messages: total,
+		latency:  newHistogram(),
+	}
+
+	for _, h := range hists {
+		res.latency.Merge(h)
+	}
+
+	return res
+}
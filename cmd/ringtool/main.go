@@ -0,0 +1,297 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+// Ringtool inspects the ring buffer snapshot files written by
+// bytebuffer.WriteSnapshotFile. It only reads snapshot files: a ring buffer lives in the
+// memory of its process, so it can't be opened while running.
+//
+// Usage:
+//
+//	ringtool info <file>
+//	ringtool verify <file>
+//	ringtool dump [-from seq] [-to seq] [-format hex|text|json] [-inflate] <file>
+//	ringtool tail [-interval duration] [-format hex|text|json] [-inflate] <file>
+//
+// info prints the slot size, slot count and the producer and consumer cursors. verify
+// checks the checksum of each entry, and prints the sequences of the corrupt ones. dump
+// prints the intact entries starting between from and to. tail polls the file, and
+// prints the new entries whenever another snapshot replaces it.
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/reducedb/ringbuffer/bytebuffer"
+	"log"
+	"os"
+	"time"
+)
+
+var _ = log.Ldate
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: ringtool <command> [flags] <snapshot file>
+
+ringtool only reads the snapshot files written by bytebuffer.WriteSnapshotFile.
+
+  ringtool info <file>
+  ringtool verify <file>
+  ringtool dump [-from seq] [-to seq] [-format hex|text|json] [-inflate] <file>
+  ringtool tail [-interval duration] [-format hex|text|json] [-inflate] <file>
+`)
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("ringtool: ")
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cmd, args := os.Args[1], os.Args[2:]
+
+	var err error
+
+	switch cmd {
+	case "info":
+		err = info(args)
+	case "verify":
+		err = verify(args)
+	case "dump":
+		err = dump(args)
+	case "tail":
+		err = tail(args)
+	default:
+		usage()
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func open(fs *flag.FlagSet, args []string) (*bytebuffer.Snapshot, error) {
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	return bytebuffer.OpenSnapshot(fs.Arg(0))
+}
+
+func info(args []string) error {
+	s, err := open(flag.NewFlagSet("info", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("slot size:  %d\n", s.SlotSize)
+	fmt.Printf("slot count: %d\n", s.SlotCount)
+	fmt.Printf("header:     %t\n", s.Header)
+	fmt.Printf("first:      %d\n", s.First)
+	fmt.Printf("last:       %d\n", s.Last)
+
+	for i, cursor := range s.Producers {
+		fmt.Printf("producer %d: %d\n", i, cursor)
+	}
+
+	for i, cursor := range s.Consumers {
+		fmt.Printf("consumer %d: %d (lag %d)\n", i, cursor, s.Last-cursor)
+	}
+
+	return nil
+}
+
+func verify(args []string) error {
+	s, err := open(flag.NewFlagSet("verify", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+
+	corrupt := s.Corrupt()
+
+	for _, seq := range corrupt {
+		fmt.Printf("entry %d: checksum mismatch\n", seq)
+	}
+
+	if len(corrupt) > 0 {
+		return fmt.Errorf("%d corrupt entries", len(corrupt))
+	}
+
+	fmt.Println("ok")
+	return nil
+}
+
+// printer writes entries in the selected format
+type printer struct {
+	format     string
+	compressor bytebuffer.Compressor
+	zbuf       []byte
+}
+
+func newPrinter(format string, inflate bool) (*printer, error) {
+	switch format {
+	case "hex", "text", "json":
+	default:
+		return nil, fmt.Errorf("invalid format %q", format)
+	}
+
+	p := &printer{
+		format: format,
+	}
+
+	if inflate {
+		var err error
+
+		if p.compressor, err = bytebuffer.NewFlateCompressor(0); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+func (this *printer) print(e bytebuffer.Entry) error {
+	if this.compressor != nil && e.Flags&bytebuffer.FlagCompressed != 0 {
+		var err error
+
+		if this.zbuf, err = this.compressor.Decompress(this.zbuf[:0], e.Data); err != nil {
+			return err
+		}
+
+		e.Data = this.zbuf
+		e.Flags &^= bytebuffer.FlagCompressed
+	}
+
+	switch this.format {
+	case "hex":
+		fmt.Printf("%d %d %d %d %s\n", e.Seq, e.Timestamp, e.Type, e.Flags, hex.EncodeToString(e.Data))
+
+	case "text":
+		fmt.Printf("%d %d %d %d %q\n", e.Seq, e.Timestamp, e.Type, e.Flags, e.Data)
+
+	case "json":
+		data, err := json.Marshal(struct {
+			Seq       int64  `json:"seq"`
+			Timestamp int64  `json:"timestamp"`
+			Type      uint16 `json:"type"`
+			Flags     uint16 `json:"flags"`
+			Data      []byte `json:"data"`
+		}{e.Seq, e.Timestamp, e.Type, e.Flags, e.Data})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s\n", data)
+	}
+
+	return nil
+}
+
+// entries prints the intact entries of s starting at or after from, and up to to. It
+// returns the sequence following the last entry printed.
+func entries(s *bytebuffer.Snapshot, from, to int64, p *printer) (int64, error) {
+	if from < s.First {
+		from = s.First
+	}
+
+	if to > s.Last {
+		to = s.Last
+	}
+
+	seq := from
+
+	for seq <= to {
+		e, err := s.Entry(seq)
+		if err == bytebuffer.ErrSkipped {
+			seq = e.Seq + 1
+			continue
+		} else if err != nil {
+			return seq, fmt.Errorf("entry %d: %v", seq, err)
+		}
+
+		if err := p.print(e); err != nil {
+			return seq, err
+		}
+
+		seq = e.Seq + 1
+	}
+
+	return seq, nil
+}
+
+func dump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	from := fs.Int64("from", 0, "first sequence to dump")
+	to := fs.Int64("to", -1, "last sequence to dump, or -1 for the last entry")
+	format := fs.String("format", "hex", "output format: hex, text or json")
+	inflate := fs.Bool("inflate", false, "decompress entries written WithCompression(flate)")
+
+	s, err := open(fs, args)
+	if err != nil {
+		return err
+	}
+
+	p, err := newPrinter(*format, *inflate)
+	if err != nil {
+		return err
+	}
+
+	if *to < 0 {
+		*to = s.Last
+	}
+
+	_, err = entries(s, *from, *to, p)
+	return err
+}
+
+func tail(args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	interval := fs.Duration("interval", time.Second, "how often to check the file for a new snapshot")
+	format := fs.String("format", "hex", "output format: hex, text or json")
+	inflate := fs.Bool("inflate", false, "decompress entries written WithCompression(flate)")
+
+	s, err := open(fs, args)
+	if err != nil {
+		return err
+	}
+
+	p, err := newPrinter(*format, *inflate)
+	if err != nil {
+		return err
+	}
+
+	path := fs.Arg(0)
+	next := s.Last + 1
+
+	var last os.FileInfo
+
+	// WriteSnapshotFile renames a new file over the old one, so a new snapshot is a
+	// different file, even if it has the same modification time
+	for {
+		if fi, err := os.Stat(path); err != nil {
+			return err
+		} else if last == nil || !os.SameFile(fi, last) || !fi.ModTime().Equal(last.ModTime()) {
+			last = fi
+
+			if s, err = bytebuffer.OpenSnapshot(path); err != nil {
+				return err
+			}
+
+			if next < s.First {
+				log.Printf("skipped %d slots overwritten before the snapshot", s.First-next)
+			}
+
+			if next, err = entries(s, next, s.Last, p); err != nil {
+				return err
+			}
+		}
+
+		time.Sleep(*interval)
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+// Package debug serves the state and the live content of a bytebuffer ring buffer over
+// HTTP, for debugging production ring buffers.
+package debug
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/reducedb/ringbuffer/bytebuffer"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+)
+
+var _ = log.Ldate
+
+const (
+	// How often the tail checks whether the client has gone away when there are no
+	// new entries
+	pollInterval = 500 * time.Millisecond
+)
+
+// Handler serves two routes, relative to where it's mounted:
+//
+//	/status   the cursors of the producers and consumers, and how far each consumer
+//	          lags behind, as JSON
+//
+//	/tail     streams the entries as they are put. The format query parameter selects
+//	          Server-Sent Events (sse, the default), JSON lines (json) or hex lines
+//	          (hex), and n stops the stream after that many entries.
+//
+// The tail uses a lossy consumer, so a slow client never holds back the producers. It
+// may miss entries instead, which are reported by /status.
+type Handler struct {
+	ring bytebuffer.RingBuffer
+}
+
+var _ http.Handler = (*Handler)(nil)
+
+func NewHandler(r bytebuffer.RingBuffer) *Handler {
+	return &Handler{
+		ring: r,
+	}
+}
+
+func (this *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch path.Base(req.URL.Path) {
+	case "status":
+		this.status(w, req)
+	case "tail":
+		this.tail(w, req)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (this *Handler) status(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(this.ring.Status())
+}
+
+// entry is the JSON representation of an entry
+type entry struct {
+	Seq       int64  `json:"seq"`
+	Timestamp int64  `json:"timestamp"`
+	Type      uint16 `json:"type"`
+	Flags     uint16 `json:"flags"`
+	Data      []byte `json:"data"`
+}
+
+func newEntry(e bytebuffer.Entry) entry {
+	return entry{
+		Seq:       e.Seq,
+		Timestamp: e.Timestamp,
+		Type:      e.Type,
+		Flags:     e.Flags,
+		Data:      e.Data,
+	}
+}
+
+func (this *Handler) tail(w http.ResponseWriter, req *http.Request) {
+	format := req.FormValue("format")
+	if format == "" {
+		format = "sse"
+	}
+
+	var limit int64
+
+	if n := req.FormValue("n"); n != "" {
+		var err error
+
+		if limit, err = strconv.ParseInt(n, 10, 64); err != nil || limit < 0 {
+			http.Error(w, "invalid n", http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch format {
+	case "sse":
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	case "json":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	case "hex":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	default:
+		http.Error(w, "invalid format", http.StatusBadRequest)
+		return
+	}
+
+	c, err := this.ring.NewConsumerWithOptions(bytebuffer.WithLossy(), bytebuffer.WithTimeout(pollInterval))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer c.Close()
+
+	flusher, _ := w.(http.Flusher)
+
+	w.WriteHeader(http.StatusOK)
+
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	ctx := req.Context()
+
+	for count := int64(0); limit == 0 || count < limit; {
+		e, err := c.GetEntry()
+		if err == bytebuffer.ErrTimeout {
+			if ctx.Err() != nil {
+				return
+			}
+
+			continue
+		} else if err != nil {
+			if format == "sse" {
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", err)
+			}
+
+			return
+		}
+
+		if err := writeEntry(w, format, e); err != nil {
+			return
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		count++
+	}
+}
+
+func writeEntry(w http.ResponseWriter, format string, e bytebuffer.Entry) error {
+	var err error
+
+	switch format {
+	case "sse":
+		var data []byte
+
+		if data, err = json.Marshal(newEntry(e)); err == nil {
+			_, err = fmt.Fprintf(w, "id: %d\nevent: entry\ndata: %s\n\n", e.Seq, data)
+		}
+
+	case "json":
+		var data []byte
+
+		if data, err = json.Marshal(newEntry(e)); err == nil {
+			_, err = fmt.Fprintf(w, "%s\n", data)
+		}
+
+	case "hex":
+		_, err = fmt.Fprintf(w, "%d %d %d %d %s\n", e.Seq, e.Timestamp, e.Type, e.Flags, hex.EncodeToString(e.Data))
+	}
+
+	return err
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package debug
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/reducedb/ringbuffer/bytebuffer"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var _ = log.Ldate
+
+func TestStatus(t *testing.T) {
+	r, err := bytebuffer.New(16, 32, bytebuffer.WithHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.NewProducer(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.NewConsumer(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(NewHandler(r.(bytebuffer.RingBuffer)))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/debug/ring/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var st bytebuffer.Status
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(st.Consumers) != 1 {
+		t.Fatalf("Expect 1 consumer, got %d", len(st.Consumers))
+	}
+
+	if resp, err := http.Get(srv.URL + "/debug/ring/unknown"); err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expect status %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestTail(t *testing.T) {
+	r, err := bytebuffer.New(16, 32, bytebuffer.WithHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(NewHandler(r.(bytebuffer.RingBuffer)))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/tail?format=json&n=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+
+			if _, err := p.(bytebuffer.Producer).PutEntry(uint16(i), 0, []byte("hello")); err != nil {
+				return
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(resp.Body)
+	count := 0
+
+	for scanner.Scan() {
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+
+		if string(e.Data) != "hello" {
+			t.Fatalf("Expect data == hello, got %q", e.Data)
+		}
+
+		count++
+	}
+
+	if count != 3 {
+		t.Fatalf("Expect 3 entries, got %d", count)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package net
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/reducedb/ringbuffer/bytebuffer"
+	"io"
+	"log"
+)
+
+var _ = log.Ldate
+
+const (
+	// Number of bytes before the data of each frame. The layout is
+	//
+	//   seq (8) | length (2) | type (2) | flags (2) | age (8) | ttl (8) | data (length)
+	//
+	// which is the sequence of the entry followed by the slot format of bytebuffer with
+	// the extended header. Nanotime is not comparable across processes, so the timestamp
+	// is sent as the age of the entry, and the expiry as the time left, or 0 if the entry
+	// never expires.
+	FrameHeaderSize = 30
+
+	// Number of bytes in each acknowledgement, which is the sequence acknowledged
+	AckSize = 8
+
+	// The length of the frame sent when the ring buffer is closed. It is larger than
+	// bytebuffer.MaxDataSize so it never clashes with a real entry.
+	closeFrameLength = 0xffff
+)
+
+var (
+	ErrFrameInvalid         = fmt.Errorf("net: Frame Invalid")
+	ErrManualCommitRequired = fmt.Errorf("net: Consumer Must Be Created WithManualCommit()")
+)
+
+func writeFrame(w *bufio.Writer, e *bytebuffer.Entry) error {
+	var hdr [FrameHeaderSize]byte
+
+	binary.LittleEndian.PutUint64(hdr[0:8], uint64(e.Seq))
+	binary.LittleEndian.PutUint16(hdr[8:10], uint16(len(e.Data)))
+	binary.LittleEndian.PutUint16(hdr[10:12], e.Type)
+	binary.LittleEndian.PutUint16(hdr[12:14], e.Flags)
+
+	if e.Timestamp != 0 || e.Expires != 0 {
+		now := bytebuffer.Nanotime()
+
+		if e.Timestamp != 0 {
+			binary.LittleEndian.PutUint64(hdr[14:22], uint64(now-e.Timestamp))
+		}
+
+		// An entry expiring right now is sent as already expired, since 0 means never
+		if ttl := e.Expires - now; e.Expires != 0 && ttl == 0 {
+			binary.LittleEndian.PutUint64(hdr[22:30], ^uint64(0))
+		} else if e.Expires != 0 {
+			binary.LittleEndian.PutUint64(hdr[22:30], uint64(ttl))
+		}
+	}
+
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+
+	_, err := w.Write(e.Data)
+	return err
+}
+
+func writeCloseFrame(w *bufio.Writer, seq int64) error {
+	var hdr [FrameHeaderSize]byte
+
+	binary.LittleEndian.PutUint64(hdr[0:8], uint64(seq))
+	binary.LittleEndian.PutUint16(hdr[8:10], closeFrameLength)
+
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// readFrame reads the next frame into buf, which must be at least bytebuffer.MaxDataSize
+// bytes long, and e, whose Timestamp and Expires are converted to the local Nanotime().
+// closed is true if this is the frame sent when the ring buffer is closed, in which
+// case only e.Seq is set.
+func readFrame(r *bufio.Reader, buf []byte, e *bytebuffer.Entry) (closed bool, err error) {
+	var hdr [FrameHeaderSize]byte
+
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return
+	}
+
+	e.Seq = int64(binary.LittleEndian.Uint64(hdr[0:8]))
+	n := int(binary.LittleEndian.Uint16(hdr[8:10]))
+
+	if n == closeFrameLength {
+		closed = true
+		return
+	}
+
+	if n > bytebuffer.MaxDataSize || n > len(buf) {
+		err = ErrFrameInvalid
+		return
+	}
+
+	e.Type = binary.LittleEndian.Uint16(hdr[10:12])
+	e.Flags = binary.LittleEndian.Uint16(hdr[12:14])
+	e.Timestamp, e.Expires = 0, 0
+
+	age := int64(binary.LittleEndian.Uint64(hdr[14:22]))
+	ttl := int64(binary.LittleEndian.Uint64(hdr[22:30]))
+
+	if age != 0 || ttl != 0 {
+		now := bytebuffer.Nanotime()
+
+		if age != 0 {
+			e.Timestamp = now - age
+		}
+
+		if ttl != 0 {
+			e.Expires = now + ttl
+		}
+	}
+
+	e.Data = buf[:n]
+	_, err = io.ReadFull(r, e.Data)
+	return
+}
+
+// putEntry puts e into the ring buffer of p with its header. If the ring buffer was not
+// created WithHeader(), only the data is put.
+func putEntry(p bytebuffer.Producer, e *bytebuffer.Entry) error {
+	_, err := p.PutEntryHeader(*e)
+	if err == bytebuffer.ErrHeaderDisabled {
+		_, err = p.Put(e.Data)
+	}
+
+	return err
+}
+
+func writeAck(w io.Writer, seq int64) error {
+	var ack [AckSize]byte
+
+	binary.LittleEndian.PutUint64(ack[:], uint64(seq))
+
+	_, err := w.Write(ack[:])
+	return err
+}
+
+func readAck(r io.Reader) (int64, error) {
+	var ack [AckSize]byte
+
+	if _, err := io.ReadFull(r, ack[:]); err != nil {
+		return 0, err
+	}
+
+	return int64(binary.LittleEndian.Uint64(ack[:])), nil
+}
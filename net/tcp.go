@@ -0,0 +1,184 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+// Package net replicates bytebuffer ring buffers to other processes.
+package net
+
+import (
+	"bufio"
+	"github.com/reducedb/ringbuffer/bytebuffer"
+	"io"
+	"log"
+	"net"
+	"sync"
+)
+
+var _ = log.Ldate
+
+// Publisher streams the entries read by a bytebuffer consumer over a connection. The
+// consumer must be created WithManualCommit(): entries are only committed once the
+// Subscriber acknowledges them, so a slow remote side eventually blocks the producers
+// of the local ring buffer. If the consumer is also created WithTimeout(), a lost
+// connection is noticed even when no entries are being sent.
+//
+// The type, flags, timestamp and expiry of the entries are replicated along with the
+// data if both ring buffers are created WithHeader().
+type Publisher struct {
+	consumer bytebuffer.Consumer
+
+	mutex  sync.Mutex
+	ackErr error
+}
+
+func NewPublisher(c bytebuffer.Consumer) *Publisher {
+	return &Publisher{
+		consumer: c,
+	}
+}
+
+// Run streams entries over conn until the ring buffer is closed and the subscriber has
+// acknowledged everything, or an error occurs. conn is closed when Run returns.
+func (this *Publisher) Run(conn net.Conn) error {
+	if !this.consumer.ManualCommit() {
+		conn.Close()
+		return ErrManualCommitRequired
+	}
+
+	this.setErr(nil)
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		this.readAcks(conn)
+	}()
+
+	// Closing the connection stops readAcks, which must not commit after Run returns
+	defer func() {
+		conn.Close()
+		wg.Wait()
+	}()
+
+	w := bufio.NewWriter(conn)
+	last := int64(-1)
+
+	for {
+		e, err := this.consumer.GetEntry()
+		if err == bytebuffer.ErrClosed {
+			if err := writeCloseFrame(w, last); err != nil {
+				return err
+			}
+
+			// The subscriber closes the connection after acknowledging the close frame
+			wg.Wait()
+			return this.err()
+		} else if err == bytebuffer.ErrTimeout {
+			if err := this.err(); err != nil {
+				return err
+			}
+
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if err := this.err(); err != nil {
+			return err
+		}
+
+		if err := writeFrame(w, &e); err != nil {
+			return err
+		}
+
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		last = e.Seq
+	}
+}
+
+// readAcks commits the entries acknowledged by the subscriber until the connection is
+// closed, or an error occurs, which is then returned by err.
+func (this *Publisher) readAcks(conn net.Conn) {
+	for {
+		seq, err := readAck(conn)
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			this.setErr(err)
+			return
+		}
+
+		if err := this.consumer.Commit(seq); err != nil {
+			this.setErr(err)
+			return
+		}
+	}
+}
+
+func (this *Publisher) setErr(err error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.ackErr = err
+}
+
+func (this *Publisher) err() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.ackErr
+}
+
+// Subscriber receives the entries sent by a Publisher and puts them into a local ring
+// buffer. An entry is acknowledged once it's in the local ring buffer, so the publisher
+// is held back whenever the local producer is.
+type Subscriber struct {
+	producer bytebuffer.Producer
+	buf      []byte
+	entry    bytebuffer.Entry
+}
+
+func NewSubscriber(p bytebuffer.Producer) *Subscriber {
+	return &Subscriber{
+		producer: p,
+		buf:      make([]byte, bytebuffer.MaxDataSize),
+	}
+}
+
+// Run receives entries from conn until the publisher's ring buffer is closed, at which
+// point the local producer is closed as well, or an error occurs.
+func (this *Subscriber) Run(conn net.Conn) error {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+
+	for {
+		e := &this.entry
+
+		closed, err := readFrame(r, this.buf, e)
+		if err != nil {
+			return err
+		}
+
+		if closed {
+			if err := writeAck(conn, e.Seq); err != nil {
+				return err
+			}
+
+			return this.producer.Close()
+		}
+
+		if err := putEntry(this.producer, e); err != nil {
+			return err
+		}
+
+		// Acknowledge once there's no complete frame waiting, to batch the acks when
+		// the publisher is ahead of us
+		if r.Buffered() < FrameHeaderSize {
+			if err := writeAck(conn, e.Seq); err != nil {
+				return err
+			}
+		}
+	}
+}
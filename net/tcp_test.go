@@ -0,0 +1,186 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package net
+
+import (
+	"bytes"
+	"github.com/reducedb/ringbuffer"
+	"github.com/reducedb/ringbuffer/bytebuffer"
+	"github.com/reducedb/ringbuffer/ringtest"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+var _ = log.Ldate
+
+// consumerOptions creates the consumers of a bytebuffer ring buffer with opts
+type consumerOptions struct {
+	bytebuffer.RingBuffer
+	opts []bytebuffer.ConsumerOption
+}
+
+func (this consumerOptions) NewConsumer() (ringbuffer.Consumer, error) {
+	return this.NewConsumerWithOptions(this.opts...)
+}
+
+// open returns a producer and a consumer created with opts of r, which was returned
+// with err by bytebuffer.New
+func open(t *testing.T, r ringbuffer.RingBuffer, err error, opts ...bytebuffer.ConsumerOption) (bytebuffer.Producer, bytebuffer.Consumer) {
+	rb, _ := r.(bytebuffer.RingBuffer)
+	p, c := ringtest.Open(t, consumerOptions{rb, opts}, err)
+
+	return p.(bytebuffer.Producer), c.(bytebuffer.Consumer)
+}
+
+func TestPublisherAndSubscriber(t *testing.T) {
+	local, err := bytebuffer.New(32, 16)
+	lp, lc := open(t, local, err, bytebuffer.WithManualCommit())
+
+	remote, err := bytebuffer.New(32, 4)
+	rp, rc := open(t, remote, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	pubErr := make(chan error, 1)
+	subErr := make(chan error, 1)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			pubErr <- err
+			return
+		}
+		defer conn.Close()
+
+		pubErr <- NewPublisher(lc).Run(conn)
+	}()
+
+	go func() {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			subErr <- err
+			return
+		}
+
+		subErr <- NewSubscriber(rp).Run(conn)
+	}()
+
+	var count = 200
+
+	go func() {
+		for i := 0; i < count; i++ {
+			if _, err := lp.Put([]byte{byte(i), byte(i >> 8), 1, 2, 3}); err != nil {
+				return
+			}
+		}
+		lp.Close()
+	}()
+
+	for i := 0; i < count; i++ {
+		// A slow remote consumer, so the backpressure reaches the local producer
+		if i%50 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		e, err := rc.GetEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(e.Data, []byte{byte(i), byte(i >> 8), 1, 2, 3}) {
+			t.Fatalf("Expect entry %d, got %v", i, e.Data)
+		}
+	}
+
+	// The close is propagated to the remote ring buffer
+	if _, err := rc.GetEntry(); err != bytebuffer.ErrClosed {
+		t.Fatalf("Expect ErrClosed, got %v", err)
+	}
+
+	if err := <-pubErr; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-subErr; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublisherRequiresManualCommit(t *testing.T) {
+	r, err := bytebuffer.New(32, 4)
+	_, c := open(t, r, err)
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	if err := NewPublisher(c).Run(server); err != ErrManualCommitRequired {
+		t.Fatalf("Expect ErrManualCommitRequired, got %v", err)
+	}
+
+	// The connection is closed
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Expect the connection to be closed")
+	}
+}
+
+func TestPublisherReplicatesHeader(t *testing.T) {
+	local, err := bytebuffer.New(32, 16, bytebuffer.WithHeader())
+	lp, lc := open(t, local, err, bytebuffer.WithManualCommit())
+
+	remote, err := bytebuffer.New(32, 16, bytebuffer.WithHeader())
+	rp, rc := open(t, remote, err)
+
+	if _, err := lp.PutEntryTTL(7, 3, []byte("ttl"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := lp.PutEntry(8, 4, []byte("plain")); err != nil {
+		t.Fatal(err)
+	}
+
+	lp.Close()
+
+	client, server := net.Pipe()
+
+	pubErr := make(chan error, 1)
+	subErr := make(chan error, 1)
+
+	go func() {
+		pubErr <- NewPublisher(lc).Run(server)
+	}()
+
+	go func() {
+		subErr <- NewSubscriber(rp).Run(client)
+	}()
+
+	e, err := rc.GetEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The expiry is sent as the time left, so it's about an hour after the timestamp
+	if e.Type != 7 || e.Flags != 3 || string(e.Data) != "ttl" || e.Timestamp == 0 || e.Expires-e.Timestamp < int64(59*time.Minute) {
+		t.Fatalf("Unexpected entry %+v", e)
+	}
+
+	if e, err = rc.GetEntry(); err != nil {
+		t.Fatal(err)
+	} else if e.Type != 8 || e.Flags != 4 || string(e.Data) != "plain" || e.Expires != 0 {
+		t.Fatalf("Unexpected entry %+v", e)
+	}
+
+	if err := <-pubErr; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-subErr; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package net
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/reducedb/ringbuffer/bytebuffer"
+	"io"
+	"log"
+	"net"
+	"sync"
+)
+
+var _ = log.Ldate
+
+const (
+	// Number of bytes at the start of each datagram. The layout is
+	//
+	//   first (8) | count (2) | count * (length (2) | data (length))
+	//
+	// where first is the number of the first message in the datagram. Messages are
+	// numbered consecutively from 0, so receivers can detect the ones they missed.
+	DatagramHeaderSize = 10
+
+	// Default maximum datagram size, which fits into a single Ethernet frame
+	DefaultMaxDatagramSize = 1472
+
+	// Default number of messages kept for retransmission
+	DefaultHistorySize = 4096
+
+	// Number of bytes in a retransmit request, which is from (8) | to (8), inclusive
+	retransmitRequestSize = 16
+)
+
+var (
+	ErrGap                     = fmt.Errorf("net: Gap In Message Sequence")
+	ErrDatagramInvalid         = fmt.Errorf("net: Datagram Invalid")
+	ErrDataExceedsDatagramSize = fmt.Errorf("net: Data Size Exceeds MaxDatagramSize")
+)
+
+// GapError is returned by MulticastReceiver.Next when messages From to To, inclusive,
+// were not received. It matches ErrGap with errors.Is.
+type GapError struct {
+	From, To uint64
+}
+
+func (this *GapError) Error() string {
+	return fmt.Sprintf("net: Gap In Message Sequence, Messages %d to %d Missing", this.From, this.To)
+}
+
+func (this *GapError) Is(target error) bool {
+	return target == ErrGap
+}
+
+// Multicaster packs the entries read by a bytebuffer consumer into UDP datagrams and
+// sends each datagram to all the destination addresses. A datagram is sent when it's
+// full, or when the consumer times out, so the consumer should be created WithTimeout(),
+// which then bounds the batching delay.
+//
+// The last HistorySize messages are kept so receivers can request them again through
+// ServeRetransmits.
+type Multicaster struct {
+	consumer bytebuffer.Consumer
+	conn     net.PacketConn
+	addrs    []net.Addr
+
+	// These can be changed before Run is called
+	MaxDatagramSize int
+	HistorySize     int
+
+	buf   []byte
+	first uint64
+	count int
+
+	// history holds a copy of the last messages, indexed by number % len(history)
+	mutex   sync.RWMutex
+	history [][]byte
+	next    uint64
+}
+
+func NewMulticaster(c bytebuffer.Consumer, conn net.PacketConn, addrs ...net.Addr) *Multicaster {
+	return &Multicaster{
+		consumer:        c,
+		conn:            conn,
+		addrs:           addrs,
+		MaxDatagramSize: DefaultMaxDatagramSize,
+		HistorySize:     DefaultHistorySize,
+	}
+}
+
+// Run sends the entries until the ring buffer is closed or an error occurs.
+func (this *Multicaster) Run() error {
+	this.mutex.Lock()
+	this.history = make([][]byte, this.HistorySize)
+	this.mutex.Unlock()
+
+	this.buf = make([]byte, DatagramHeaderSize, this.MaxDatagramSize)
+
+	for {
+		e, err := this.consumer.GetEntry()
+		if err == bytebuffer.ErrClosed {
+			return this.flush()
+		} else if err == bytebuffer.ErrTimeout {
+			if err := this.flush(); err != nil {
+				return err
+			}
+
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if DatagramHeaderSize+2+len(e.Data) > this.MaxDatagramSize {
+			return ErrDataExceedsDatagramSize
+		}
+
+		if len(this.buf)+2+len(e.Data) > this.MaxDatagramSize {
+			if err := this.flush(); err != nil {
+				return err
+			}
+		}
+
+		this.add(e.Data)
+	}
+}
+
+func (this *Multicaster) add(data []byte) {
+	var size [2]byte
+
+	binary.LittleEndian.PutUint16(size[:], uint16(len(data)))
+	this.buf = append(this.buf, size[:]...)
+	this.buf = append(this.buf, data...)
+
+	this.mutex.Lock()
+
+	if this.count == 0 {
+		this.first = this.next
+	}
+
+	if len(this.history) > 0 {
+		i := this.next % uint64(len(this.history))
+		this.history[i] = append(this.history[i][:0], data...)
+	}
+
+	this.next++
+	this.mutex.Unlock()
+
+	this.count++
+}
+
+func (this *Multicaster) flush() error {
+	if this.count == 0 {
+		return nil
+	}
+
+	binary.LittleEndian.PutUint64(this.buf[0:8], this.first)
+	binary.LittleEndian.PutUint16(this.buf[8:10], uint16(this.count))
+
+	for _, addr := range this.addrs {
+		if _, err := this.conn.WriteTo(this.buf, addr); err != nil {
+			return err
+		}
+	}
+
+	this.buf = this.buf[:DatagramHeaderSize]
+	this.count = 0
+
+	return nil
+}
+
+// ServeRetransmits accepts connections from l and answers retransmit requests from the
+// receivers until Accept fails.
+func (this *Multicaster) ServeRetransmits(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go this.retransmit(conn)
+	}
+}
+
+func (this *Multicaster) retransmit(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	var req [retransmitRequestSize]byte
+
+	for {
+		if _, err := io.ReadFull(r, req[:]); err != nil {
+			return
+		}
+
+		from := binary.LittleEndian.Uint64(req[0:8])
+		to := binary.LittleEndian.Uint64(req[8:16])
+
+		// Copy the messages that are still in the history, so that a slow receiver
+		// doesn't hold the lock while they are written
+		this.mutex.RLock()
+
+		if size := uint64(len(this.history)); this.next > size && from < this.next-size {
+			from = this.next - size
+		}
+
+		var msgs [][]byte
+
+		for n := from; n <= to && n < this.next && len(this.history) > 0; n++ {
+			msgs = append(msgs, append([]byte(nil), this.history[n%uint64(len(this.history))]...))
+		}
+
+		this.mutex.RUnlock()
+
+		// Send them, followed by a close frame
+		for i, msg := range msgs {
+			if err := writeFrame(w, &bytebuffer.Entry{Seq: int64(from) + int64(i), Data: msg}); err != nil {
+				return
+			}
+		}
+
+		if err := writeCloseFrame(w, int64(to)); err != nil {
+			return
+		}
+	}
+}
+
+// MulticastReceiver reads the datagrams sent by a Multicaster and detects the messages
+// that were lost.
+type MulticastReceiver struct {
+	conn       net.PacketConn
+	retransmit string
+
+	retransConn   net.Conn
+	retransReader *bufio.Reader
+
+	// expected is the number of the next message
+	expected uint64
+
+	buf  []byte
+	data []byte
+	num  uint64
+	left int
+}
+
+// NewMulticastReceiver returns a receiver reading datagrams from conn. retransmit is the
+// TCP address of the Multicaster's ServeRetransmits, or "" if retransmission is not used.
+func NewMulticastReceiver(conn net.PacketConn, retransmit string) *MulticastReceiver {
+	return &MulticastReceiver{
+		conn:       conn,
+		retransmit: retransmit,
+		buf:        make([]byte, 64*1024),
+	}
+}
+
+// Next returns the next message and its number. If some messages were lost, a
+// *GapError is returned first, and the following call continues with the message
+// after the gap. The data is only valid until the next call.
+func (this *MulticastReceiver) Next() (uint64, []byte, error) {
+	for {
+		for this.left == 0 {
+			n, _, err := this.conn.ReadFrom(this.buf)
+			if err != nil {
+				return 0, nil, err
+			}
+
+			if n < DatagramHeaderSize {
+				return 0, nil, ErrDatagramInvalid
+			}
+
+			this.num = binary.LittleEndian.Uint64(this.buf[0:8])
+			this.left = int(binary.LittleEndian.Uint16(this.buf[8:10]))
+			this.data = this.buf[DatagramHeaderSize:n]
+		}
+
+		for this.left > 0 {
+			if len(this.data) < 2 {
+				this.left = 0
+				return 0, nil, ErrDatagramInvalid
+			}
+
+			size := int(binary.LittleEndian.Uint16(this.data[0:2]))
+			if len(this.data) < 2+size {
+				this.left = 0
+				return 0, nil, ErrDatagramInvalid
+			}
+
+			if this.num > this.expected {
+				gap := &GapError{From: this.expected, To: this.num - 1}
+				this.expected = this.num
+				return 0, nil, gap
+			}
+
+			msg, num := this.data[2:2+size], this.num
+
+			this.data = this.data[2+size:]
+			this.num++
+			this.left--
+
+			// Skip the messages that were already received
+			if num < this.expected {
+				continue
+			}
+
+			this.expected = num + 1
+			return num, msg, nil
+		}
+	}
+}
+
+// Retransmit requests messages from to to, inclusive, from the Multicaster. Messages
+// that are no longer in its history are missing from the result, which maps the message
+// numbers to their data.
+func (this *MulticastReceiver) Retransmit(from, to uint64) (map[uint64][]byte, error) {
+	if this.retransConn == nil {
+		conn, err := net.Dial("tcp", this.retransmit)
+		if err != nil {
+			return nil, err
+		}
+
+		this.retransConn = conn
+		this.retransReader = bufio.NewReader(conn)
+	}
+
+	var req [retransmitRequestSize]byte
+
+	binary.LittleEndian.PutUint64(req[0:8], from)
+	binary.LittleEndian.PutUint64(req[8:16], to)
+
+	if _, err := this.retransConn.Write(req[:]); err != nil {
+		this.closeRetransmit()
+		return nil, err
+	}
+
+	r := this.retransReader
+	buf := make([]byte, bytebuffer.MaxDataSize)
+	msgs := make(map[uint64][]byte)
+
+	var e bytebuffer.Entry
+
+	for {
+		closed, err := readFrame(r, buf, &e)
+		if err != nil {
+			this.closeRetransmit()
+			return nil, err
+		}
+
+		if closed {
+			return msgs, nil
+		}
+
+		msgs[uint64(e.Seq)] = append([]byte(nil), e.Data...)
+	}
+}
+
+// Close closes the retransmit connection, if any. conn is left to the caller.
+func (this *MulticastReceiver) Close() error {
+	this.closeRetransmit()
+	return nil
+}
+
+func (this *MulticastReceiver) closeRetransmit() {
+	if this.retransConn != nil {
+		this.retransConn.Close()
+		this.retransConn = nil
+		this.retransReader = nil
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package net
+
+import (
+	"bytes"
+	"errors"
+	"github.com/reducedb/ringbuffer/bytebuffer"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+var _ = log.Ldate
+
+// lossyPacketConn drops the datagram number drop sent to addr
+type lossyPacketConn struct {
+	net.PacketConn
+	addr  string
+	drop  int
+	count int
+}
+
+func (this *lossyPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if addr.String() == this.addr {
+		this.count++
+		if this.count == this.drop {
+			return len(b), nil
+		}
+	}
+
+	return this.PacketConn.WriteTo(b, addr)
+}
+
+func testMessage(i int) []byte {
+	return []byte{byte(i), 1, 2, 3, 4, 5, 6, 7, 8, 9}
+}
+
+func TestMulticasterGapAndRetransmit(t *testing.T) {
+	local, err := bytebuffer.New(16, 64)
+	lp, lc := open(t, local, err, bytebuffer.WithTimeout(time.Millisecond))
+
+	var count = 20
+
+	// Everything is in the ring buffer before sending starts, so each datagram is full
+	for i := 0; i < count; i++ {
+		if _, err := lp.Put(testMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lp.Close()
+
+	var conns [3]net.PacketConn
+
+	for i := range conns {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		conns[i] = conn
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// 4 messages of 12 bytes fit into each datagram, and the receiver 2 misses the
+	// second datagram, i.e., messages 4 to 7
+	lossy := &lossyPacketConn{PacketConn: conns[0], addr: conns[2].LocalAddr().String(), drop: 2}
+
+	m := NewMulticaster(lc, lossy, conns[1].LocalAddr(), conns[2].LocalAddr())
+	m.MaxDatagramSize = DatagramHeaderSize + 4*12
+
+	go m.ServeRetransmits(l)
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	r1 := NewMulticastReceiver(conns[1], "")
+
+	for i := 0; i < count; i++ {
+		num, data, err := r1.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if num != uint64(i) || !bytes.Equal(data, testMessage(i)) {
+			t.Fatalf("Expect message %d, got %d: %v", i, num, data)
+		}
+	}
+
+	r2 := NewMulticastReceiver(conns[2], l.Addr().String())
+	defer r2.Close()
+
+	for i := 0; i < count; i++ {
+		num, data, err := r2.Next()
+
+		if i == 4 {
+			var gap *GapError
+
+			if !errors.Is(err, ErrGap) || !errors.As(err, &gap) {
+				t.Fatalf("Expect ErrGap, got %v", err)
+			}
+
+			if gap.From != 4 || gap.To != 7 {
+				t.Fatalf("Expect gap from 4 to 7, got %d to %d", gap.From, gap.To)
+			}
+
+			msgs, err := r2.Retransmit(gap.From, gap.To)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for n := gap.From; n <= gap.To; n++ {
+				if !bytes.Equal(msgs[n], testMessage(int(n))) {
+					t.Fatalf("Expect retransmitted message %d, got %v", n, msgs[n])
+				}
+			}
+
+			i = int(gap.To)
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if num != uint64(i) || !bytes.Equal(data, testMessage(i)) {
+			t.Fatalf("Expect message %d, got %d: %v", i, num, data)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package net
+
+import (
+	"bufio"
+	"github.com/reducedb/ringbuffer/bytebuffer"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ = log.Ldate
+
+const (
+	// Default time between reconnection attempts
+	DefaultRetryInterval = 100 * time.Millisecond
+)
+
+// Sender writes the entries read by a bytebuffer consumer to a Unix domain socket. The
+// consumer must be created WithManualCommit(), so the entries that have not been
+// acknowledged by the Receiver remain in the ring buffer. When the connection is lost,
+// the Sender reconnects and resumes from the last entry acknowledged. The consumer
+// should also be created WithTimeout(), otherwise a lost connection is only noticed
+// when the next entry is sent.
+type Sender struct {
+	consumer bytebuffer.Consumer
+	path     string
+
+	// Time to wait between reconnection attempts
+	RetryInterval time.Duration
+
+	// ErrorHandler, if set, is called with the error that ended each connection before
+	// reconnecting
+	ErrorHandler func(error)
+
+	stop chan struct{}
+	once sync.Once
+
+	// acked is the last sequence acknowledged by the receiver
+	acked int64
+
+	mutex  sync.Mutex
+	ackErr error
+}
+
+func NewSender(c bytebuffer.Consumer, path string) *Sender {
+	return &Sender{
+		consumer:      c,
+		path:          path,
+		RetryInterval: DefaultRetryInterval,
+		stop:          make(chan struct{}),
+		acked:         -1,
+	}
+}
+
+// Run sends entries until the ring buffer is closed and the receiver has acknowledged
+// all of them, Close is called, or the consumer returns an error. Connection errors
+// cause a reconnection instead.
+func (this *Sender) Run() error {
+	if !this.consumer.ManualCommit() {
+		return ErrManualCommitRequired
+	}
+
+	for {
+		select {
+		case <-this.stop:
+			return nil
+		default:
+		}
+
+		conn, err := net.Dial("unix", this.path)
+		if err == nil {
+			var done bool
+
+			done, err = this.session(conn)
+			conn.Close()
+
+			if done {
+				return err
+			}
+		}
+
+		if this.ErrorHandler != nil {
+			this.ErrorHandler(err)
+		}
+
+		select {
+		case <-this.stop:
+			return nil
+		case <-time.After(this.RetryInterval):
+		}
+	}
+}
+
+// Close stops Run from reconnecting.
+func (this *Sender) Close() error {
+	this.once.Do(func() {
+		close(this.stop)
+	})
+
+	return nil
+}
+
+// session sends entries over conn. done is false if a new connection should be made.
+func (this *Sender) session(conn net.Conn) (done bool, err error) {
+	// The receiver starts by sending the last sequence it has received
+	last, err := readAck(conn)
+	if err != nil {
+		return false, err
+	}
+
+	if err := this.consumer.Rewind(last); err == bytebuffer.ErrInvalidSequence && last < atomic.LoadInt64(&this.acked) {
+		// The receiver has lost entries it acknowledged, e.g. it was restarted. They
+		// can't be sent again, so resume with the oldest entry still available.
+		last = atomic.LoadInt64(&this.acked)
+
+		if err := this.consumer.Rewind(last); err != nil {
+			return true, err
+		}
+	} else if err != nil {
+		return true, err
+	}
+
+	if err := this.consumer.Commit(last); err != nil {
+		return true, err
+	}
+
+	this.setErr(nil)
+	atomic.StoreInt64(&this.acked, last)
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		this.readAcks(conn)
+	}()
+
+	// Make sure the acks from this connection are not committed after the next one
+	// has started
+	defer func() {
+		conn.Close()
+		wg.Wait()
+	}()
+
+	w := bufio.NewWriter(conn)
+
+	for {
+		e, err := this.consumer.GetEntry()
+		if err == bytebuffer.ErrClosed {
+			if err := writeCloseFrame(w, last); err != nil {
+				return false, err
+			}
+
+			// The receiver closes the connection after acknowledging the close frame
+			wg.Wait()
+
+			if atomic.LoadInt64(&this.acked) != last {
+				return false, this.err()
+			}
+
+			return true, nil
+		} else if err == bytebuffer.ErrTimeout {
+			if err := this.err(); err != nil {
+				return false, err
+			}
+
+			continue
+		} else if err != nil {
+			return true, err
+		}
+
+		if err := this.err(); err != nil {
+			return false, err
+		}
+
+		if err := writeFrame(w, &e); err != nil {
+			return false, err
+		}
+
+		if err := w.Flush(); err != nil {
+			return false, err
+		}
+
+		last = e.Seq
+	}
+}
+
+func (this *Sender) readAcks(conn net.Conn) {
+	for {
+		seq, err := readAck(conn)
+		if err != nil {
+			this.setErr(err)
+			return
+		}
+
+		if err := this.consumer.Commit(seq); err != nil {
+			this.setErr(err)
+			return
+		}
+
+		atomic.StoreInt64(&this.acked, seq)
+	}
+}
+
+func (this *Sender) setErr(err error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.ackErr = err
+}
+
+func (this *Sender) err() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.ackErr
+}
+
+// Receiver accepts connections from a Sender and puts the entries received into a local
+// ring buffer. It remembers the last sequence received, so entries resent after a
+// reconnection are only put once.
+type Receiver struct {
+	// ErrorHandler, if set, is called with the error that ended each connection before
+	// waiting for the next one
+	ErrorHandler func(error)
+
+	producer bytebuffer.Producer
+	last     int64
+	buf      []byte
+	entry    bytebuffer.Entry
+}
+
+func NewReceiver(p bytebuffer.Producer) *Receiver {
+	return &Receiver{
+		producer: p,
+		last:     -1,
+		buf:      make([]byte, bytebuffer.MaxDataSize),
+	}
+}
+
+// Serve accepts connections from l, one at a time, until the sender's ring buffer is
+// closed, at which point the local producer is closed as well, or Accept fails. An
+// error putting an entry into the local ring buffer is returned too, while connection
+// errors are passed to ErrorHandler.
+func (this *Receiver) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		done, err := this.session(conn)
+		conn.Close()
+
+		if done {
+			if err != nil {
+				return err
+			}
+
+			return this.producer.Close()
+		}
+
+		if this.ErrorHandler != nil {
+			this.ErrorHandler(err)
+		}
+	}
+}
+
+// session receives entries over conn. done is false if the sender should reconnect.
+func (this *Receiver) session(conn net.Conn) (done bool, err error) {
+	if err := writeAck(conn, this.last); err != nil {
+		return false, err
+	}
+
+	r := bufio.NewReader(conn)
+
+	for {
+		e := &this.entry
+
+		closed, err := readFrame(r, this.buf, e)
+		if err != nil {
+			return false, err
+		}
+
+		if closed {
+			if err := writeAck(conn, this.last); err != nil {
+				return false, err
+			}
+
+			return true, nil
+		}
+
+		if e.Seq > this.last {
+			if err := putEntry(this.producer, e); err != nil {
+				return true, err
+			}
+
+			this.last = e.Seq
+		}
+
+		if r.Buffered() < FrameHeaderSize {
+			if err := writeAck(conn, this.last); err != nil {
+				return false, err
+			}
+		}
+	}
+}
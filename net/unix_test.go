@@ -0,0 +1,238 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package net
+
+import (
+	"bytes"
+	"errors"
+	"github.com/reducedb/ringbuffer/bytebuffer"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var _ = log.Ldate
+
+// flakyListener drops each of the first drops connections after limit bytes are read
+type flakyListener struct {
+	net.Listener
+	drops int
+	limit int
+}
+
+func (this *flakyListener) Accept() (net.Conn, error) {
+	conn, err := this.Listener.Accept()
+	if err != nil || this.drops == 0 {
+		return conn, err
+	}
+
+	this.drops--
+	return &flakyConn{Conn: conn, limit: this.limit}, nil
+}
+
+type flakyConn struct {
+	net.Conn
+	limit int
+}
+
+func (this *flakyConn) Read(b []byte) (int, error) {
+	if this.limit <= 0 {
+		this.Conn.Close()
+		return 0, net.ErrClosed
+	}
+
+	if len(b) > this.limit {
+		b = b[:this.limit]
+	}
+
+	n, err := this.Conn.Read(b)
+	this.limit -= n
+	return n, err
+}
+
+func TestSenderAndReceiverReconnect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ringbuffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ring.sock")
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	local, err := bytebuffer.New(32, 16)
+	lp, lc := open(t, local, err, bytebuffer.WithManualCommit(), bytebuffer.WithTimeout(time.Millisecond))
+
+	remote, err := bytebuffer.New(32, 256)
+	rp, rc := open(t, remote, err)
+
+	sender := NewSender(lc, path)
+	sender.RetryInterval = time.Millisecond
+
+	senderErr := make(chan error, 1)
+	receiverErr := make(chan error, 1)
+
+	go func() {
+		senderErr <- sender.Run()
+	}()
+
+	go func() {
+		// Drop the first 3 connections in the middle of a frame
+		receiverErr <- NewReceiver(rp).Serve(&flakyListener{Listener: l, drops: 3, limit: 155})
+	}()
+
+	var count = 100
+
+	for i := 0; i < count; i++ {
+		if _, err := lp.Put([]byte{byte(i), 1, 2, 3, 4, 5, 6, 7}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lp.Close()
+
+	for i := 0; i < count; i++ {
+		e, err := rc.GetEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(e.Data, []byte{byte(i), 1, 2, 3, 4, 5, 6, 7}) {
+			t.Fatalf("Expect entry %d, got %v", i, e.Data)
+		}
+	}
+
+	if _, err := rc.GetEntry(); err != bytebuffer.ErrClosed {
+		t.Fatalf("Expect ErrClosed, got %v", err)
+	}
+
+	if err := <-senderErr; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-receiverErr; err != nil {
+		t.Fatal(err)
+	}
+}
+
+var errStopped = errors.New("stopped")
+
+// oneShotListener accepts a single connection, like a receiver that is then restarted
+type oneShotListener struct {
+	net.Listener
+	accepted bool
+}
+
+func (this *oneShotListener) Accept() (net.Conn, error) {
+	if this.accepted {
+		return nil, errStopped
+	}
+
+	this.accepted = true
+	return this.Listener.Accept()
+}
+
+func TestSenderResyncsRestartedReceiver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ringbuffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ring.sock")
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	local, err := bytebuffer.New(32, 16)
+	lp, lc := open(t, local, err, bytebuffer.WithManualCommit(), bytebuffer.WithTimeout(time.Millisecond))
+
+	remote, err := bytebuffer.New(32, 256)
+	rp, rc := open(t, remote, err)
+
+	sender := NewSender(lc, path)
+	sender.RetryInterval = time.Millisecond
+
+	senderErr := make(chan error, 1)
+	receiverErr := make(chan error, 1)
+	sessionErrs := make(chan error, 1)
+
+	go func() {
+		senderErr <- sender.Run()
+	}()
+
+	go func() {
+		// The first receiver loses its connection in the middle of a frame and stops
+		first := NewReceiver(rp)
+		first.ErrorHandler = func(err error) {
+			sessionErrs <- err
+		}
+
+		if err := first.Serve(&flakyListener{Listener: &oneShotListener{Listener: l}, drops: 1, limit: 200}); err != errStopped {
+			receiverErr <- err
+			return
+		}
+
+		// The next one starts from scratch, so the sender resumes with the oldest entry
+		// it still has
+		receiverErr <- NewReceiver(rp).Serve(l)
+	}()
+
+	var count = 100
+
+	go func() {
+		for i := 0; i < count; i++ {
+			if _, err := lp.Put([]byte{byte(i), 1, 2, 3, 4, 5, 6, 7}); err != nil {
+				return
+			}
+		}
+
+		lp.Close()
+	}()
+
+	// The entries put but not acknowledged by the first receiver are sent again, but
+	// none are skipped
+	prev := -1
+
+	for prev < count-1 {
+		e, err := rc.GetEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i := int(e.Data[0]); i > prev+1 {
+			t.Fatalf("Expect entry %d, got %d", prev+1, i)
+		} else {
+			prev = i
+		}
+	}
+
+	if _, err := rc.GetEntry(); err != bytebuffer.ErrClosed {
+		t.Fatalf("Expect ErrClosed, got %v", err)
+	}
+
+	if err := <-sessionErrs; err == nil {
+		t.Fatal("Expect the first session to fail")
+	}
+
+	if err := <-senderErr; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-receiverErr; err != nil {
+		t.Fatal(err)
+	}
+}
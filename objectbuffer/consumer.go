@@ -0,0 +1,138 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package objectbuffer
+
+import (
+	"github.com/reducedb/ringbuffer"
+	"github.com/reducedb/ringbuffer/sequence"
+	"log"
+)
+
+var _ = log.Ldate
+
+// Consumer is implemented by the consumers returned from NewConsumer.
+type Consumer interface {
+	ringbuffer.Consumer
+
+	// Close lets the producer reuse the objects the consumer hasn't read yet. Pending
+	// and future calls to Get return ErrClosed.
+	Close() error
+}
+
+type consumer struct {
+	buffer *objectBuffer
+	seq    ringbuffer.Sequencer
+	reader *sequence.Reader
+
+	// read is the sequence of the last object read. It's committed by the following call
+	// to Get, so that the producer cannot reuse the object while the caller is still
+	// using it.
+	read    int64
+	pending bool
+}
+
+var _ Consumer = (*consumer)(nil)
+
+// NewConsumer creates a consumer that starts with the next object published.
+func (this *objectBuffer) NewConsumer() (ringbuffer.Consumer, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	seq, err := sequence.NewConsumer(len(this.slots))
+	if err != nil {
+		return nil, err
+	}
+
+	seq.SetWaitStrategy(this.waitStrategy)
+
+	// The producer claims objects without the mutex, so it must be gated before the
+	// consumer picks where it starts. Until it sees the new gate, it can't claim more
+	// than a lap past its cursor, so the objects after the cursor read below are kept.
+	seq.Set(this.publishedLocked())
+
+	for _, p := range this.producers {
+		p.seq.AddGatingSequence(seq)
+	}
+
+	// The objects published before may have been reused already
+	published := this.publishedLocked()
+	seq.Set(published)
+	this.waitStrategy.Signal()
+
+	for _, p := range this.producers {
+		seq.AddGatingSequence(p.seq)
+	}
+
+	// Get would otherwise wait for the next Put forever
+	if this.isClosed() {
+		seq.Alert()
+	}
+
+	c := &consumer{
+		buffer: this,
+		seq:    seq,
+		reader: sequence.NewReader(seq, seq.(*sequence.Consumer).Barrier(), published),
+		read:   published,
+	}
+
+	this.consumers = append(this.consumers, c)
+
+	return c, nil
+}
+
+// removeConsumer lets the producer reuse the objects c was holding, and forgets about it
+func (this *objectBuffer) removeConsumer(c *consumer) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for i, v := range this.consumers {
+		if v == c {
+			this.consumers = append(this.consumers[:i], this.consumers[i+1:]...)
+			break
+		}
+	}
+
+	for _, p := range this.producers {
+		p.seq.RemoveGatingSequence(c.seq)
+	}
+}
+
+func (this *consumer) Close() error {
+	if !this.reader.Close() {
+		return nil
+	}
+
+	this.buffer.removeConsumer(this)
+
+	// The producer may be waiting to claim the object this consumer held
+	this.buffer.waitStrategy.Signal()
+
+	return nil
+}
+
+// Get returns the next object published. The object is shared with the producer and
+// the other consumers, so it must not be changed, and it's only valid until the next
+// call.
+func (this *consumer) Get() (interface{}, error) {
+	if this.reader.IsClosed() {
+		return nil, ErrClosed
+	}
+
+	if this.pending {
+		this.seq.Commit(this.read)
+		this.buffer.waitStrategy.Signal()
+		this.pending = false
+	}
+
+	if err := this.reader.Wait(this.read + 1); err == sequence.ErrAlerted {
+		return nil, ErrClosed
+	} else if err != nil {
+		return nil, err
+	}
+
+	this.read++
+	this.pending = true
+
+	return this.buffer.slot(this.read), nil
+}
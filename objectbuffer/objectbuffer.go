@@ -0,0 +1,137 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+// Objectbuffer implements a ring buffer of preallocated objects, the way the LMAX
+// Disruptor reuses its events. Every slot holds an object created by the factory given
+// to New. A producer claims the next slot, fills in its object and publishes it, and the
+// consumers read the same object back, so nothing is allocated or serialized on the way.
+package objectbuffer
+
+import (
+	"fmt"
+	"github.com/reducedb/ringbuffer"
+	"github.com/reducedb/ringbuffer/sequence"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+var _ = log.Ldate
+
+const (
+	MaxProducerCount = 1
+)
+
+var (
+	ErrDataInvalid              = fmt.Errorf("objectbuffer: Data Must Be a Translator")
+	ErrNotPowerOfTwo            = fmt.Errorf("objectbuffer: Slot Count Must Be Power of Two")
+	ErrNilFactory               = fmt.Errorf("objectbuffer: Factory Is Nil")
+	ErrMaxProducerCountExceeded = fmt.Errorf("objectbuffer: This ringbuffer only allows %d producer(s)", MaxProducerCount)
+	ErrNotClaimed               = fmt.Errorf("objectbuffer: Sequence Not Claimed")
+	ErrAlreadyClaimed           = fmt.Errorf("objectbuffer: Claimed Sequence Not Published")
+	ErrClosed                   = fmt.Errorf("objectbuffer: Ring Buffer Closed")
+)
+
+// Factory creates the object held by a slot. It's called once per slot by New.
+type Factory func() interface{}
+
+// Translator fills in the object of the slot claimed by Put.
+type Translator func(obj interface{})
+
+// RingBuffer is implemented by the ring buffers returned from New.
+type RingBuffer interface {
+	ringbuffer.RingBuffer
+
+	// Close stops the producers from publishing more objects. Consumers will continue
+	// to read the remaining objects, after which ErrClosed is returned.
+	Close() error
+
+	// SlotCount returns the number of objects in the ring buffer
+	SlotCount() int
+}
+
+type objectBuffer struct {
+	slots []interface{}
+	mask  int64
+
+	waitStrategy ringbuffer.WaitStrategy
+
+	producers []*producer
+	consumers []*consumer
+	mutex     sync.RWMutex
+
+	closed int32
+}
+
+var _ RingBuffer = (*objectBuffer)(nil)
+
+// New creates a ring buffer of slotCount objects, which must be a power of two, each
+// created by factory.
+func New(slotCount int, factory Factory, opts ...Option) (ringbuffer.RingBuffer, error) {
+	if !ringbuffer.PowerOfTwo(slotCount) {
+		return nil, ErrNotPowerOfTwo
+	}
+
+	if factory == nil {
+		return nil, ErrNilFactory
+	}
+
+	d := &objectBuffer{
+		slots:        make([]interface{}, slotCount),
+		mask:         int64(slotCount - 1),
+		waitStrategy: ringbuffer.NewYieldingWait(),
+	}
+
+	for i := range d.slots {
+		d.slots[i] = factory()
+	}
+
+	for _, opt := range opts {
+		if err := opt(d); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+func (this *objectBuffer) Close() error {
+	atomic.StoreInt32(&this.closed, 1)
+
+	// Wake up the producers waiting for room, and the consumers waiting for objects
+	this.mutex.RLock()
+	for _, p := range this.producers {
+		p.seq.Alert()
+	}
+
+	for _, c := range this.consumers {
+		c.reader.Interrupt()
+	}
+	this.mutex.RUnlock()
+
+	this.waitStrategy.Signal()
+	return nil
+}
+
+func (this *objectBuffer) isClosed() bool {
+	return atomic.LoadInt32(&this.closed) == 1
+}
+
+func (this *objectBuffer) SlotCount() int {
+	return len(this.slots)
+}
+
+// slot returns the object at sequence seq
+func (this *objectBuffer) slot(seq int64) interface{} {
+	return this.slots[seq&this.mask]
+}
+
+// publishedLocked returns the last sequence committed by the producer
+func (this *objectBuffer) publishedLocked() int64 {
+	if len(this.producers) == 0 {
+		return sequence.InitialSequenceValue
+	}
+
+	cursor, _ := this.producers[0].seq.Get()
+	return cursor
+}
@@ -0,0 +1,281 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package objectbuffer
+
+import (
+	"github.com/reducedb/ringbuffer"
+	"github.com/reducedb/ringbuffer/ringtest"
+	"log"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var _ = log.Ldate
+
+type event struct {
+	seq   int64
+	value int
+}
+
+func newEvent() interface{} {
+	return &event{}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(3, newEvent); err != ErrNotPowerOfTwo {
+		t.Fatalf("Expect ErrNotPowerOfTwo, got %v", err)
+	}
+
+	if _, err := New(4, nil); err != ErrNilFactory {
+		t.Fatalf("Expect ErrNilFactory, got %v", err)
+	}
+
+	calls := 0
+
+	r, err := New(8, func() interface{} {
+		calls++
+		return &event{}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 8 {
+		t.Fatalf("Expect the factory to be called 8 times, got %d", calls)
+	}
+
+	if _, err := r.NewProducer(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.NewProducer(); err != ErrMaxProducerCountExceeded {
+		t.Fatalf("Expect ErrMaxProducerCountExceeded, got %v", err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	ringtest.Conformance(t, ringtest.Engine{
+		New: func() (ringbuffer.RingBuffer, error) {
+			return New(4, newEvent)
+		},
+		Entry: func(i int) interface{} {
+			return func(obj interface{}) { obj.(*event).value = i }
+		},
+		Check: func(v interface{}, i int) bool {
+			return v.(*event).value == i
+		},
+		ErrClosed: ErrClosed,
+	})
+}
+
+func TestClaimPublish(t *testing.T) {
+	r, err := New(4, newEvent)
+	rp, c := ringtest.Open(t, r, err)
+	p := rp.(Producer)
+
+	seen := make(map[interface{}]bool)
+
+	for i := 0; i < 10; i++ {
+		seq, obj, err := p.Claim()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := p.Claim(); err != ErrAlreadyClaimed {
+			t.Fatalf("Expect ErrAlreadyClaimed, got %v", err)
+		}
+
+		obj.(*event).seq = seq
+		obj.(*event).value = i * 10
+
+		if err := p.Publish(seq); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := p.Publish(seq); err != ErrNotClaimed {
+			t.Fatalf("Expect ErrNotClaimed, got %v", err)
+		}
+
+		out, err := c.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if out != obj || out.(*event).seq != int64(i) || out.(*event).value != i*10 {
+			t.Fatalf("Expect event %d, got %v", i, out)
+		}
+
+		seen[out] = true
+	}
+
+	// The objects are reused rather than allocated
+	if len(seen) != 4 {
+		t.Fatalf("Expect 4 objects, got %d", len(seen))
+	}
+}
+
+func TestPut(t *testing.T) {
+	r, err := New(4, newEvent)
+	p, c := ringtest.Open(t, r, err)
+
+	if _, err := p.Put(1); err != ErrDataInvalid {
+		t.Fatalf("Expect ErrDataInvalid, got %v", err)
+	}
+
+	if _, err := p.Put(Translator(func(obj interface{}) { obj.(*event).value = 1 })); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Put(func(obj interface{}) { obj.(*event).value = 2 }); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		if out, err := c.Get(); err != nil {
+			t.Fatal(err)
+		} else if out.(*event).value != i {
+			t.Fatalf("Expect value %d, got %d", i, out.(*event).value)
+		}
+	}
+}
+
+func TestObjectHeldByConsumer(t *testing.T) {
+	r, err := New(2, newEvent)
+	rp, c := ringtest.Open(t, r, err)
+	p := rp.(Producer)
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.Put(func(obj interface{}) { obj.(*event).value = i }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	held, err := c.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The next Claim reuses the object of the first slot, which the consumer is still
+	// using, so it waits for the consumer's next Get
+	claimed := make(chan interface{}, 1)
+
+	go func() {
+		_, obj, err := p.Claim()
+		if err != nil {
+			claimed <- err
+			return
+		}
+
+		claimed <- obj
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+
+	select {
+	case v := <-claimed:
+		t.Fatalf("Expect Claim to wait, got %v", v)
+	default:
+	}
+
+	if held.(*event).value != 0 {
+		t.Fatalf("Expect value 0, got %d", held.(*event).value)
+	}
+
+	if _, err := c.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	if obj := <-claimed; obj != held {
+		t.Fatalf("Expect the first object to be reused, got %v", obj)
+	}
+}
+
+func BenchmarkClaimPublish(b *testing.B) {
+	r, err := New(1024, newEvent)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	c, _ := r.NewConsumer()
+	p, _ := r.NewProducer()
+	n := b.N
+
+	go func() {
+		for i := 0; i < n; i++ {
+			seq, obj, err := p.(Producer).Claim()
+			if err != nil {
+				b.Error(err)
+				return
+			}
+
+			obj.(*event).value = i
+			p.(Producer).Publish(seq)
+		}
+	}()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Get(); err != nil {
+			b.Error(err)
+			return
+		}
+	}
+}
+
+func TestConsumerWhileProducing(t *testing.T) {
+	r, err := New(16, newEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stop int32
+	errc := make(chan error, 1)
+
+	go func() {
+		for i := 0; atomic.LoadInt32(&stop) == 0; i++ {
+			if _, err := p.Put(func(obj interface{}) { obj.(*event).value = i }); err != nil {
+				errc <- err
+				return
+			}
+		}
+
+		errc <- nil
+	}()
+
+	// A new consumer reads consecutive objects, which the producer doesn't reuse under it
+	for k := 0; k < 1000; k++ {
+		c, err := r.NewConsumer()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var last int
+
+		for j := 0; j < 20; j++ {
+			out, err := c.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if v := out.(*event).value; j > 0 && v != last+1 {
+				t.Fatalf("Consumer %d: expect object %d, got %d", k, last+1, v)
+			} else {
+				last = v
+			}
+		}
+
+		c.(Consumer).Close()
+	}
+
+	atomic.StoreInt32(&stop, 1)
+
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
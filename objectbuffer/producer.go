@@ -0,0 +1,131 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package objectbuffer
+
+import (
+	"github.com/reducedb/ringbuffer"
+	"github.com/reducedb/ringbuffer/sequence"
+	"log"
+)
+
+var _ = log.Ldate
+
+// Producer is implemented by the producers returned from NewProducer.
+type Producer interface {
+	ringbuffer.Producer
+
+	// Claim waits for the next slot to be free and returns its sequence and object. The
+	// object may be changed in place until the sequence is passed to Publish, which must
+	// be done before the next Claim.
+	Claim() (int64, interface{}, error)
+
+	// Publish makes the object claimed with sequence seq visible to the consumers.
+	Publish(seq int64) error
+
+	// Close marks the producer as done, which closes the ring buffer.
+	Close() error
+}
+
+type producer struct {
+	buffer *objectBuffer
+	seq    ringbuffer.Sequencer
+
+	// claimed is the sequence returned by Claim that has not been published yet
+	claimed int64
+}
+
+var _ Producer = (*producer)(nil)
+
+func (this *objectBuffer) NewProducer() (ringbuffer.Producer, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if len(this.producers) >= MaxProducerCount {
+		return nil, ErrMaxProducerCountExceeded
+	}
+
+	seq, err := sequence.NewProducer(len(this.slots))
+	if err != nil {
+		return nil, err
+	}
+
+	seq.SetWaitStrategy(this.waitStrategy)
+
+	p := &producer{
+		buffer:  this,
+		seq:     seq,
+		claimed: sequence.InitialSequenceValue,
+	}
+
+	this.producers = append(this.producers, p)
+
+	for _, c := range this.consumers {
+		p.seq.AddGatingSequence(c.seq)
+		c.seq.AddGatingSequence(p.seq)
+	}
+
+	return p, nil
+}
+
+func (this *producer) Close() error {
+	return this.buffer.Close()
+}
+
+func (this *producer) Claim() (int64, interface{}, error) {
+	if this.buffer.isClosed() {
+		return 0, nil, ErrClosed
+	}
+
+	if this.claimed != sequence.InitialSequenceValue {
+		return 0, nil, ErrAlreadyClaimed
+	}
+
+	seq, err := this.seq.Request(1)
+	if err == sequence.ErrAlerted {
+		return 0, nil, ErrClosed
+	} else if err != nil {
+		return 0, nil, err
+	}
+
+	this.claimed = seq
+
+	return seq, this.buffer.slot(seq), nil
+}
+
+func (this *producer) Publish(seq int64) error {
+	if seq != this.claimed || seq == sequence.InitialSequenceValue {
+		return ErrNotClaimed
+	}
+
+	this.claimed = sequence.InitialSequenceValue
+
+	this.seq.Commit(seq)
+	this.buffer.waitStrategy.Signal()
+
+	return nil
+}
+
+// Put claims the next slot, calls data, which must be a Translator, with its object, and
+// publishes it. Returns the number of objects published, which is always 1.
+func (this *producer) Put(data interface{}) (int, error) {
+	fn, ok := data.(Translator)
+	if !ok {
+		if fn, ok = data.(func(interface{})); !ok {
+			return 0, ErrDataInvalid
+		}
+	}
+
+	seq, obj, err := this.Claim()
+	if err != nil {
+		return 0, err
+	}
+
+	fn(obj)
+
+	if err := this.Publish(seq); err != nil {
+		return 0, err
+	}
+
+	return 1, nil
+}
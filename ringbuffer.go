@@ -29,9 +29,23 @@ type Sequencer interface {
 	Set(int64) error
 	Next(int) (int64, error)
 	Request(int) (int64, error)
+
+	// WaitFor blocks until seq is available and returns the highest sequence available,
+	// which may be greater than seq, so the caller can process all the sequences up to
+	// it before waiting again. For a producer, it's the highest sequence that can be
+	// claimed without overwriting the gating sequences.
+	WaitFor(int64) (int64, error)
+
 	Commit(int64) error
 	AddGatingSequence(...Sequencer)
 	RemoveGatingSequence(Sequencer)
+	SetWaitStrategy(WaitStrategy)
+
+	// Alert makes the calls to Request and WaitFor blocked on the gating sequences
+	// return an error right away, and so do the future calls until ClearAlert. It's
+	// meant for shutting down or reconfiguring the sequencers.
+	Alert()
+	ClearAlert()
 }
 
 func GetMinSeq(gates []Sequencer, min int64) (int64, error) {
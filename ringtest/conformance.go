@@ -0,0 +1,212 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package ringtest
+
+import (
+	"github.com/reducedb/ringbuffer"
+	"log"
+	"testing"
+	"time"
+)
+
+var _ = log.Ldate
+
+// Engine describes a storage engine to Conformance
+type Engine struct {
+	// New creates an empty ring buffer with a single producer. It must have room for
+	// three entries at any position, as the last one read is held until the next Get,
+	// but not many more, so that the tests wrap around it.
+	New func() (ringbuffer.RingBuffer, error)
+
+	// Entry returns the i-th entry to put, and Check tells whether v, returned by Get,
+	// is that entry.
+	Entry func(i int) interface{}
+	Check func(v interface{}, i int) bool
+
+	// ErrClosed is the engine's error for a closed ring buffer or consumer
+	ErrClosed error
+}
+
+// closer is implemented by the producers and consumers of all the engines. Closing the
+// producer closes the ring buffer, as it's the only one.
+type closer interface {
+	Close() error
+}
+
+// Open returns a consumer and a producer of r, which was returned with err by the
+// constructor of a storage engine. The consumer is created first, so that it reads all
+// the entries put. It fails tb if any of them returns an error.
+func Open(tb testing.TB, r ringbuffer.RingBuffer, err error) (ringbuffer.Producer, ringbuffer.Consumer) {
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	c, err := r.NewConsumer()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return p, c
+}
+
+// Conformance runs the tests that all the storage engines must pass, on how entries are
+// read and how closing wakes up the producer and the consumers.
+func Conformance(t *testing.T, e Engine) {
+	t.Run("PutGet", func(t *testing.T) {
+		p, c := open(t, e)
+
+		// Many times around the buffer, then a couple left when it's closed
+		for i := 0; i < 100; i++ {
+			put(t, e, p, i)
+			get(t, e, c, i)
+		}
+
+		put(t, e, p, 100)
+		put(t, e, p, 101)
+		p.(closer).Close()
+
+		get(t, e, c, 100)
+		get(t, e, c, 101)
+
+		if _, err := c.Get(); err != e.ErrClosed {
+			t.Fatalf("Expect ErrClosed, got %v", err)
+		}
+	})
+
+	t.Run("CloseWakesGet", func(t *testing.T) {
+		p, c := open(t, e)
+
+		errc := getAsync(c)
+
+		time.Sleep(10 * time.Millisecond)
+		p.(closer).Close()
+
+		if err := <-errc; err != e.ErrClosed {
+			t.Fatalf("Expect ErrClosed, got %v", err)
+		}
+	})
+
+	t.Run("ConsumerCloseWakesGet", func(t *testing.T) {
+		p, c := open(t, e)
+
+		errc := getAsync(c)
+
+		time.Sleep(10 * time.Millisecond)
+		c.(closer).Close()
+
+		if err := <-errc; err != e.ErrClosed {
+			t.Fatalf("Expect ErrClosed, got %v", err)
+		}
+
+		// Without a consumer the producer is never held back
+		done := make(chan bool)
+
+		go func() {
+			defer close(done)
+
+			for i := 0; i < 100; i++ {
+				if _, err := p.Put(e.Entry(i)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expect the producer not to wait for a closed consumer")
+		}
+
+		if _, err := c.Get(); err != e.ErrClosed {
+			t.Fatalf("Expect ErrClosed, got %v", err)
+		}
+	})
+
+	t.Run("CloseWakesPut", func(t *testing.T) {
+		p, _ := open(t, e)
+
+		// The consumer doesn't read anything, so the producer runs out of room
+		errc := make(chan error, 1)
+
+		go func() {
+			for i := 0; ; i++ {
+				if _, err := p.Put(e.Entry(i)); err != nil {
+					errc <- err
+					return
+				}
+			}
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+		p.(closer).Close()
+
+		if err := <-errc; err != e.ErrClosed {
+			t.Fatalf("Expect ErrClosed, got %v", err)
+		}
+	})
+
+	t.Run("ConsumerAfterClose", func(t *testing.T) {
+		r, err := e.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := r.NewProducer()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p.(closer).Close()
+
+		// A consumer created after the close either fails, or doesn't wait
+		c, err := r.NewConsumer()
+		if err == nil {
+			_, err = c.Get()
+		}
+
+		if err != e.ErrClosed {
+			t.Fatalf("Expect ErrClosed, got %v", err)
+		}
+	})
+}
+
+func open(t *testing.T, e Engine) (ringbuffer.Producer, ringbuffer.Consumer) {
+	r, err := e.New()
+	return Open(t, r, err)
+}
+
+func put(t *testing.T, e Engine, p ringbuffer.Producer, i int) {
+	if _, err := p.Put(e.Entry(i)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func get(t *testing.T, e Engine, c ringbuffer.Consumer, i int) {
+	v, err := c.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !e.Check(v, i) {
+		t.Fatalf("Expect entry %d, got %v", i, v)
+	}
+}
+
+// getAsync calls Get in a goroutine, and returns the channel receiving its error
+func getAsync(c ringbuffer.Consumer) chan error {
+	errc := make(chan error, 1)
+
+	go func() {
+		_, err := c.Get()
+		errc <- err
+	}()
+
+	return errc
+}
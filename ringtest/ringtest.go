@@ -0,0 +1,238 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+// Package ringtest provides a deterministic scheduler for testing sequencers and ring
+// buffers under specific interleavings of producers and consumers.
+//
+// Each producer or consumer runs as an Actor in its own goroutine, but only one actor
+// runs at a time: the test decides which one runs next with Step, Run or RunRandom. An
+// actor runs until it parks, either because it waits on its sequencer, which uses the
+// actor as its WaitStrategy, or because it calls Yield to mark an interesting point,
+// such as between requesting and committing a sequence.
+package ringtest
+
+import (
+	"fmt"
+	"github.com/reducedb/ringbuffer"
+	"log"
+	"math/rand"
+	"sort"
+)
+
+var _ = log.Ldate
+
+var (
+	ErrUnknownActor = fmt.Errorf("ringtest: Unknown Actor")
+	ErrActorDone    = fmt.Errorf("ringtest: Actor Is Done")
+	ErrStepLimit    = fmt.Errorf("ringtest: Step Limit Reached")
+)
+
+// State is the state of an actor after a step
+type State int
+
+const (
+	// Created actors have not run yet
+	Created State = iota
+
+	// Yielded actors called Yield
+	Yielded
+
+	// Waiting actors are waiting on a sequence, i.e. their WaitStrategy was called
+	Waiting
+
+	// Done actors have returned
+	Done
+)
+
+func (this State) String() string {
+	switch this {
+	case Created:
+		return "created"
+	case Yielded:
+		return "yielded"
+	case Waiting:
+		return "waiting"
+	case Done:
+		return "done"
+	}
+
+	return fmt.Sprintf("State(%d)", int(this))
+}
+
+// Actor is a producer or consumer run by the Scheduler. It implements
+// ringbuffer.WaitStrategy, so it parks whenever its sequencer has to wait.
+type Actor struct {
+	name   string
+	sched  *Scheduler
+	resume chan struct{}
+	state  State
+	err    error
+
+	// Number of times the actor parked in Wait
+	waits int
+}
+
+var _ ringbuffer.WaitStrategy = (*Actor)(nil)
+
+func (this *Actor) Name() string {
+	return this.name
+}
+
+func (this *Actor) State() State {
+	return this.state
+}
+
+// Err returns the error returned by the actor, once it's done
+func (this *Actor) Err() error {
+	return this.err
+}
+
+// Waits returns the number of times the actor waited on a sequence
+func (this *Actor) Waits() int {
+	return this.waits
+}
+
+// Wait parks the actor until the scheduler steps it again.
+func (this *Actor) Wait(retries int) {
+	this.waits++
+	this.park(Waiting)
+}
+
+func (this *Actor) Signal() {}
+
+// Yield parks the actor until the scheduler steps it again.
+func (this *Actor) Yield() {
+	this.park(Yielded)
+}
+
+func (this *Actor) park(s State) {
+	this.state = s
+	this.sched.parked <- this
+	<-this.resume
+}
+
+// Scheduler runs actors one step at a time. It is not safe for concurrent use; it's
+// meant to be driven by a single test goroutine.
+type Scheduler struct {
+	actors map[string]*Actor
+	names  []string
+	parked chan *Actor
+	checks []func() error
+
+	// Log of the actors stepped, in order
+	Trace []string
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		actors: make(map[string]*Actor),
+		parked: make(chan *Actor),
+	}
+}
+
+// Go adds an actor that runs fn once it's first stepped. fn should use the actor as
+// the WaitStrategy of its sequencers, and return an error instead of failing the test,
+// since it doesn't run in the test goroutine.
+func (this *Scheduler) Go(name string, fn func(a *Actor) error) *Actor {
+	a := &Actor{
+		name:   name,
+		sched:  this,
+		resume: make(chan struct{}),
+	}
+
+	this.actors[name] = a
+	this.names = append(this.names, name)
+	sort.Strings(this.names)
+
+	go func() {
+		<-a.resume
+
+		a.err = fn(a)
+		a.state = Done
+		this.parked <- a
+	}()
+
+	return a
+}
+
+// Check adds an invariant that is checked after every step. The first failing check
+// stops Step, Run and RunRandom with its error.
+func (this *Scheduler) Check(fn func() error) {
+	this.checks = append(this.checks, fn)
+}
+
+// Actor returns the actor with the name, or nil
+func (this *Scheduler) Actor(name string) *Actor {
+	return this.actors[name]
+}
+
+// Step runs the actor until it parks or returns, and then checks the invariants. It
+// returns the state the actor is left in.
+func (this *Scheduler) Step(name string) (State, error) {
+	a, ok := this.actors[name]
+	if !ok {
+		return 0, ErrUnknownActor
+	}
+
+	if a.state == Done {
+		return Done, ErrActorDone
+	}
+
+	this.Trace = append(this.Trace, name)
+
+	a.resume <- struct{}{}
+	<-this.parked
+
+	for _, check := range this.checks {
+		if err := check(); err != nil {
+			return a.state, fmt.Errorf("ringtest: step %d (%s): %v", len(this.Trace), name, err)
+		}
+	}
+
+	if a.state == Done && a.err != nil {
+		return Done, fmt.Errorf("ringtest: %s: %v", name, a.err)
+	}
+
+	return a.state, nil
+}
+
+// Run steps the actors in the order given.
+func (this *Scheduler) Run(order ...string) error {
+	for _, name := range order {
+		if _, err := this.Step(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RunRandom steps actors picked by a random source seeded with seed until they are all
+// done, so a failing interleaving can be replayed with the same seed. ErrStepLimit is
+// returned after maxSteps, which usually means the actors are deadlocked.
+func (this *Scheduler) RunRandom(seed int64, maxSteps int) error {
+	rnd := rand.New(rand.NewSource(seed))
+	runnable := make([]string, 0, len(this.names))
+
+	for steps := 0; ; steps++ {
+		runnable = runnable[:0]
+
+		for _, name := range this.names {
+			if this.actors[name].state != Done {
+				runnable = append(runnable, name)
+			}
+		}
+
+		if len(runnable) == 0 {
+			return nil
+		}
+
+		if steps >= maxSteps {
+			return ErrStepLimit
+		}
+
+		if _, err := this.Step(runnable[rnd.Intn(len(runnable))]); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package ringtest
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"testing"
+)
+
+var _ = log.Ldate
+
+func TestStep(t *testing.T) {
+	s := NewScheduler()
+
+	var events []string
+
+	s.Go("a", func(a *Actor) error {
+		events = append(events, "a1")
+		a.Yield()
+		events = append(events, "a2")
+		a.Wait(1)
+		events = append(events, "a3")
+		return nil
+	})
+
+	s.Go("b", func(a *Actor) error {
+		events = append(events, "b1")
+		return fmt.Errorf("failed")
+	})
+
+	if state, err := s.Step("a"); err != nil || state != Yielded {
+		t.Fatalf("Expect yielded, got %v, %v", state, err)
+	}
+
+	if state, err := s.Step("a"); err != nil || state != Waiting {
+		t.Fatalf("Expect waiting, got %v, %v", state, err)
+	}
+
+	if s.Actor("a").Waits() != 1 {
+		t.Fatalf("Expect 1 wait, got %d", s.Actor("a").Waits())
+	}
+
+	if _, err := s.Step("b"); err == nil {
+		t.Fatal("Expect the error returned by b")
+	}
+
+	if state, err := s.Step("a"); err != nil || state != Done {
+		t.Fatalf("Expect done, got %v, %v", state, err)
+	}
+
+	if _, err := s.Step("a"); err != ErrActorDone {
+		t.Fatalf("Expect ErrActorDone, got %v", err)
+	}
+
+	if _, err := s.Step("c"); err != ErrUnknownActor {
+		t.Fatalf("Expect ErrUnknownActor, got %v", err)
+	}
+
+	if expect := []string{"a1", "a2", "b1", "a3"}; !reflect.DeepEqual(events, expect) {
+		t.Fatalf("Expect events %v, got %v", expect, events)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	s := NewScheduler()
+
+	n := 0
+
+	s.Go("a", func(a *Actor) error {
+		for {
+			n++
+			a.Yield()
+		}
+	})
+
+	s.Check(func() error {
+		if n > 2 {
+			return fmt.Errorf("n == %d", n)
+		}
+		return nil
+	})
+
+	if err := s.Run("a", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Step("a"); err == nil {
+		t.Fatal("Expect the check to fail")
+	}
+}
+
+func TestRunRandom(t *testing.T) {
+	trace := func(seed int64) []string {
+		s := NewScheduler()
+
+		for _, name := range []string{"a", "b", "c"} {
+			s.Go(name, func(a *Actor) error {
+				for i := 0; i < 5; i++ {
+					a.Yield()
+				}
+				return nil
+			})
+		}
+
+		if err := s.RunRandom(seed, 100); err != nil {
+			t.Fatal(err)
+		}
+
+		return s.Trace
+	}
+
+	if !reflect.DeepEqual(trace(1), trace(1)) {
+		t.Fatal("Expect the same trace for the same seed")
+	}
+
+	s := NewScheduler()
+
+	s.Go("a", func(a *Actor) error {
+		for {
+			a.Wait(1)
+		}
+	})
+
+	if err := s.RunRandom(1, 10); err != ErrStepLimit {
+		t.Fatalf("Expect ErrStepLimit, got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package sequence
+
+import (
+	"errors"
+	"github.com/reducedb/ringbuffer"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ = log.Ldate
+
+var (
+	ErrAlerted = errors.New("sequence.Barrier: Alerted")
+	ErrTimeout = errors.New("sequence.Barrier: Timed Out Waiting For Sequence")
+)
+
+// Barrier tracks the sequences a sequencer depends on, i.e. the producers for a consumer,
+// and the consumers for a producer, and waits for them with a wait strategy. A barrier
+// may be shared by several sequencers, so that a consumer can depend on other consumers,
+// or so that a single Alert wakes up all of them.
+type Barrier struct {
+	// deps points to the sequences the barrier depends on. The slice is never modified
+	// in place: Add and Remove store a new one, so WaitFor can read it without a lock.
+	// depsMutex only serializes the writers.
+	deps      atomic.Pointer[[]ringbuffer.Sequencer]
+	depsMutex sync.Mutex
+
+	// waitStrategy is read by WaitFor without synchronization, so it's only set before
+	// the barrier is used
+	waitStrategy ringbuffer.WaitStrategy
+
+	// timeout is the longest WaitFor waits, in nanoseconds, or 0 to wait forever
+	timeout int64
+
+	// empty is returned by Min when there are no dependencies
+	empty int64
+
+	alerted int32
+}
+
+// NewBarrier creates a barrier waiting on deps with w. If w is nil, it busy spins.
+func NewBarrier(w ringbuffer.WaitStrategy, deps ...ringbuffer.Sequencer) *Barrier {
+	if w == nil {
+		w = ringbuffer.NewBusySpinWait()
+	}
+
+	b := &Barrier{
+		waitStrategy: w,
+		empty:        math.MaxInt64,
+	}
+
+	d := append([]ringbuffer.Sequencer(nil), deps...)
+	b.deps.Store(&d)
+
+	return b
+}
+
+// Deps returns the sequences the barrier depends on. The slice must not be modified.
+func (this *Barrier) Deps() []ringbuffer.Sequencer {
+	if deps := this.deps.Load(); deps != nil {
+		return *deps
+	}
+
+	return nil
+}
+
+// Add adds sequences to depend on.
+func (this *Barrier) Add(deps ...ringbuffer.Sequencer) {
+	this.depsMutex.Lock()
+	defer this.depsMutex.Unlock()
+
+	old := this.Deps()
+
+	// Copy, rather than append, so the slice read by WaitFor is never written to
+	n := make([]ringbuffer.Sequencer, 0, len(old)+len(deps))
+	n = append(n, old...)
+	n = append(n, deps...)
+
+	this.deps.Store(&n)
+}
+
+// Remove stops depending on dep.
+func (this *Barrier) Remove(dep ringbuffer.Sequencer) {
+	this.depsMutex.Lock()
+	defer this.depsMutex.Unlock()
+
+	old := this.Deps()
+	deps := make([]ringbuffer.Sequencer, 0, len(old))
+
+	for _, d := range old {
+		if d != dep {
+			deps = append(deps, d)
+		}
+	}
+
+	this.deps.Store(&deps)
+}
+
+// SetWaitStrategy sets what WaitFor does while the dependent sequences are behind. It
+// must be called before the barrier is used: it's not safe to call while another
+// goroutine may be in WaitFor or Alert.
+func (this *Barrier) SetWaitStrategy(w ringbuffer.WaitStrategy) {
+	this.waitStrategy = w
+}
+
+// SetTimeout makes WaitFor return ErrTimeout if the sequence isn't available within d.
+// 0 waits forever.
+func (this *Barrier) SetTimeout(d time.Duration) {
+	atomic.StoreInt64(&this.timeout, int64(d))
+}
+
+// Min returns the lowest of the dependent sequences. If there are none, it returns
+// math.MaxInt64, unless the barrier belongs to a consumer, which has nothing to read
+// without producers, in which case it returns InitialSequenceValue.
+func (this *Barrier) Min() (int64, error) {
+	deps := this.Deps()
+	if len(deps) == 0 {
+		return this.empty, nil
+	}
+
+	return ringbuffer.GetMinSeq(deps, math.MaxInt64)
+}
+
+// WaitFor blocks until all the dependent sequences have reached seq, and returns the
+// lowest of them, which may be greater than seq. It returns ErrAlerted if the barrier
+// is alerted while waiting, or before, and ErrTimeout if the timeout expires first.
+func (this *Barrier) WaitFor(seq int64) (int64, error) {
+	var deadline time.Time
+
+	for retries := 1; ; retries++ {
+		if this.IsAlerted() {
+			return 0, ErrAlerted
+		}
+
+		if minSeq, err := this.Min(); err != nil {
+			return 0, err
+		} else if seq <= minSeq {
+			return minSeq, nil
+		}
+
+		if timeout := atomic.LoadInt64(&this.timeout); timeout > 0 {
+			if now := time.Now(); deadline.IsZero() {
+				deadline = now.Add(time.Duration(timeout))
+			} else if now.After(deadline) {
+				return 0, ErrTimeout
+			}
+		}
+
+		this.waitStrategy.Wait(retries)
+	}
+}
+
+// Alert wakes up the sequencers waiting on the barrier, whose calls return ErrAlerted
+// until ClearAlert is called. It's meant for shutting down.
+func (this *Barrier) Alert() {
+	atomic.StoreInt32(&this.alerted, 1)
+	this.waitStrategy.Signal()
+}
+
+// ClearAlert lets the sequencers wait on the barrier again.
+func (this *Barrier) ClearAlert() {
+	atomic.StoreInt32(&this.alerted, 0)
+}
+
+// IsAlerted returns whether Alert has been called since the last ClearAlert.
+func (this *Barrier) IsAlerted() bool {
+	return atomic.LoadInt32(&this.alerted) == 1
+}
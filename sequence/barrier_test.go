@@ -0,0 +1,298 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package sequence
+
+import (
+	"github.com/reducedb/ringbuffer"
+	"log"
+	"math"
+	"runtime"
+	"testing"
+	"time"
+)
+
+var _ = log.Ldate
+
+func newProducer(t *testing.T) ringbuffer.Sequencer {
+	s, err := NewProducer(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestBarrierWaitFor(t *testing.T) {
+	s1, s2 := newProducer(t), newProducer(t)
+	s1.Set(5)
+	s2.Set(3)
+
+	b := NewBarrier(nil)
+
+	if v, err := b.Min(); err != nil {
+		t.Fatal(err)
+	} else if v != math.MaxInt64 {
+		t.Fatalf("Expect math.MaxInt64 without dependencies, got %d", v)
+	}
+
+	b.Add(s1, s2)
+
+	if v, err := b.WaitFor(2); err != nil {
+		t.Fatal(err)
+	} else if v != 3 {
+		t.Fatalf("Expect v == 3, got %d", v)
+	}
+
+	b.Remove(s2)
+
+	if v, err := b.WaitFor(4); err != nil {
+		t.Fatal(err)
+	} else if v != 5 {
+		t.Fatalf("Expect v == 5, got %d", v)
+	}
+}
+
+func TestBarrierTimeout(t *testing.T) {
+	s := newProducer(t)
+
+	b := NewBarrier(ringbuffer.NewYieldingWait(), s)
+	b.SetTimeout(10 * time.Millisecond)
+
+	if _, err := b.WaitFor(0); err != ErrTimeout {
+		t.Fatalf("Expect ErrTimeout, got %v", err)
+	}
+
+	s.Set(0)
+
+	if _, err := b.WaitFor(0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestConsumerWithoutProducers pins the behavior of a consumer without gating
+// sequences. It used to return from Request at once, as if everything was committed;
+// now it waits until a producer commits, or it's alerted or times out.
+func TestConsumerWithoutProducers(t *testing.T) {
+	cseq, err := NewConsumer(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := cseq.(*Consumer).Barrier()
+	b.SetTimeout(10 * time.Millisecond)
+
+	if _, err := cseq.Request(1); err != ErrTimeout {
+		t.Fatalf("Expect ErrTimeout, got %v", err)
+	}
+
+	b.SetTimeout(0)
+
+	errc := make(chan error, 1)
+
+	go func() {
+		_, err := cseq.Request(1)
+		errc <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cseq.Alert()
+
+	if err := <-errc; err != ErrAlerted {
+		t.Fatalf("Expect ErrAlerted, got %v", err)
+	}
+
+	cseq.ClearAlert()
+
+	pseq := newProducer(t)
+	cseq.AddGatingSequence(pseq)
+	pseq.Next(1)
+
+	if seq, err := cseq.Request(1); err != nil || seq != 0 {
+		t.Fatalf("Expect sequence 0, got %d, %v", seq, err)
+	}
+
+	// A producer without consumers is never held back
+	if seq, err := newProducer(t).Request(8); err != nil || seq != 7 {
+		t.Fatalf("Expect sequence 7, got %d, %v", seq, err)
+	}
+}
+
+func TestBarrierAlert(t *testing.T) {
+	pseq := newProducer(t)
+
+	// Both consumers share a barrier, so a single Alert stops both
+	b := NewBarrier(ringbuffer.NewBlockingWait(time.Second), pseq)
+
+	errc := make(chan error, 2)
+
+	for i := 0; i < 2; i++ {
+		cseq, err := NewConsumer(4)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cseq.(*Consumer).SetBarrier(b)
+
+		go func(cseq ringbuffer.Sequencer) {
+			_, err := cseq.Request(1)
+			errc <- err
+		}(cseq)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	b.Alert()
+
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != ErrAlerted {
+			t.Fatalf("Expect ErrAlerted, got %v", err)
+		}
+	}
+
+	b.ClearAlert()
+	pseq.Next(1)
+
+	if _, err := b.WaitFor(0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestBarrierPipeline chains two consumers, so the second only sees the sequences the
+// first has committed, and the producer waits for the second one only.
+func TestBarrierPipeline(t *testing.T) {
+	const ringSize = 16
+	var ring [ringSize]int64
+	var ringMask int64 = ringSize - 1
+
+	pseq, err := NewProducer(ringSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := NewConsumer(ringSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := NewConsumer(ringSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pseq.SetWaitStrategy(ringbuffer.NewYieldingWait())
+	pseq.AddGatingSequence(second)
+	first.AddGatingSequence(pseq)
+	second.AddGatingSequence(first)
+
+	const count = 10000
+
+	errc := make(chan error, 2)
+
+	go func() {
+		for i := int64(0); i < count; i++ {
+			seq, err := pseq.Request(1)
+			if err != nil {
+				errc <- err
+				return
+			}
+
+			ring[seq&ringMask] = seq
+			pseq.Commit(seq)
+		}
+	}()
+
+	// The first consumer doubles each value in place
+	go func() {
+		for next := int64(0); next < count; {
+			available, err := first.WaitFor(next)
+			if err != nil {
+				errc <- err
+				return
+			}
+
+			for ; next <= available; next++ {
+				ring[next&ringMask] *= 2
+			}
+
+			first.Commit(available)
+		}
+
+		errc <- nil
+	}()
+
+	for next := int64(0); next < count; next++ {
+		seq, err := second.Request(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v := ring[seq&ringMask]; v != 2*seq {
+			t.Fatalf("Expect %d, got %d", 2*seq, v)
+		}
+
+		second.Commit(seq)
+	}
+
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestBarrierConcurrentUpdates adds and removes consumers while the producer is waiting
+// on them. Run with -race to check that WaitFor reads the dependencies safely.
+func TestBarrierConcurrentUpdates(t *testing.T) {
+	pseq, err := NewProducer(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pseq.SetWaitStrategy(ringbuffer.NewYieldingWait())
+
+	done := make(chan struct{})
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 1000; i++ {
+			cseq, err := NewConsumer(4)
+			if err != nil {
+				errc <- err
+				return
+			}
+
+			// Never hold back the producer by more than the buffer
+			cursor, _ := pseq.Get()
+			cseq.Set(cursor)
+
+			pseq.AddGatingSequence(cseq)
+			runtime.Gosched()
+			pseq.RemoveGatingSequence(cseq)
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+		default:
+			if _, err := pseq.Next(1); err != nil {
+				t.Fatal(err)
+			}
+
+			runtime.Gosched()
+			continue
+		}
+
+		break
+	}
+
+	select {
+	case err := <-errc:
+		t.Fatal(err)
+	default:
+	}
+
+	if deps := pseq.(*Producer).Barrier().Deps(); len(deps) != 0 {
+		t.Fatalf("Expect no gating sequences left, got %d", len(deps))
+	}
+}
@@ -6,8 +6,6 @@ package sequence
 import (
 	"github.com/reducedb/ringbuffer"
 	"log"
-	"math"
-	"runtime"
 )
 
 var _ = log.Ldate
@@ -27,6 +25,11 @@ func NewConsumer(bufferSize int) (ringbuffer.Sequencer, error) {
 	s.cursor = InitialSequenceValue
 	s.cachedGate = InitialSequenceValue
 	s.bufferSize = bufferSize
+	s.barrier = NewBarrier(ringbuffer.NewYieldingWait())
+
+	// Without producers, there is nothing to read, so Request waits until one is added
+	// and commits, rather than returning at once
+	s.barrier.empty = InitialSequenceValue
 
 	return s, nil
 }
@@ -56,15 +59,9 @@ func (this *Consumer) Request(n int) (int64, error) {
 	cachedGate := this.cachedGate
 
 	if nextSeq > cachedGate {
-		var minSeq int64
-		var err error
-
-		for minSeq, err = ringbuffer.GetMinSeq(this.gates, math.MaxInt64); nextSeq > minSeq; minSeq, err = ringbuffer.GetMinSeq(this.gates, math.MaxInt64) {
-			//log.Printf("consumer nextSeq = %d, minSeq = %d\n", nextSeq, minSeq)
-			if err != nil {
-				return 0, err
-			}
-			runtime.Gosched()
+		minSeq, err := this.barrier.WaitFor(nextSeq)
+		if err != nil {
+			return 0, err
 		}
 
 		this.cachedGate = minSeq
@@ -72,3 +69,15 @@ func (this *Consumer) Request(n int) (int64, error) {
 
 	return nextSeq, nil
 }
+
+// WaitFor blocks until the producers have committed seq, and returns the lowest sequence
+// committed by all of them, which is the highest sequence the consumer can read.
+func (this *Consumer) WaitFor(seq int64) (int64, error) {
+	minSeq, err := this.barrier.WaitFor(seq)
+	if err != nil {
+		return 0, err
+	}
+
+	this.cachedGate = minSeq
+	return minSeq, nil
+}
@@ -25,6 +25,7 @@ func NewProducer(bufferSize int) (ringbuffer.Sequencer, error) {
 	s.cursor = InitialSequenceValue
 	s.cachedGate = InitialSequenceValue
 	s.bufferSize = bufferSize
+	s.barrier = NewBarrier(ringbuffer.NewBusySpinWait())
 
 	return s, nil
 }
@@ -63,14 +64,14 @@ func (this *Producer) Request(n int) (int64, error) {
 	//
 	// TODO: Figure out what "cachedGate > next" means
 	if wrapPoint > cachedGate || cachedGate > cursor {
-		var minSeq int64
-		var err error
-
-		for minSeq, err = ringbuffer.GetMinSeq(this.gates, cursor); wrapPoint > minSeq; minSeq, err = ringbuffer.GetMinSeq(this.gates, cursor) {
-			if err != nil {
-				return 0, err
-			}
-			//runtime.Gosched()
+		minSeq, err := this.barrier.WaitFor(wrapPoint)
+		if err != nil {
+			return 0, err
+		}
+
+		// Without gating sequences, the producer is only limited by its own cursor
+		if minSeq > cursor {
+			minSeq = cursor
 		}
 
 		this.cachedGate = minSeq
@@ -78,3 +79,25 @@ func (this *Producer) Request(n int) (int64, error) {
 
 	return nextSeq, nil
 }
+
+// WaitFor blocks until seq can be claimed without overwriting a sequence the consumers
+// haven't committed, and returns the highest sequence that can be claimed. It doesn't
+// claim anything; Request still has to be called.
+func (this *Producer) WaitFor(seq int64) (int64, error) {
+	cursor, err := this.Get()
+	if err != nil {
+		return 0, err
+	}
+
+	minSeq, err := this.barrier.WaitFor(seq - int64(this.bufferSize))
+	if err != nil {
+		return 0, err
+	}
+
+	if minSeq > cursor {
+		minSeq = cursor
+	}
+
+	this.cachedGate = minSeq
+	return minSeq + int64(this.bufferSize), nil
+}
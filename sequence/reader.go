@@ -0,0 +1,87 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package sequence
+
+import (
+	"github.com/reducedb/ringbuffer"
+	"log"
+	"sync/atomic"
+)
+
+var _ = log.Ldate
+
+// Reader is the waiting side of a consumer of a storage engine. It waits for the
+// producers through the consumer's sequencer, and remembers the highest sequence they
+// have committed, so a single wake-up serves all the entries committed by then. It also
+// tells a consumer that was closed, and won't read anything more, from one that was only
+// alerted, e.g. because the ring buffer was closed, and still has entries to read.
+//
+// Wait must only be called from the goroutine reading the entries. Close and Interrupt
+// may be called from any goroutine.
+type Reader struct {
+	seq     ringbuffer.Sequencer
+	barrier *Barrier
+
+	// available is the last sequence known to be committed by the producers
+	available int64
+
+	closed int32
+}
+
+// NewReader returns a reader waiting on seq, whose gating sequences are held by barrier,
+// knowing that the producers have committed up to available.
+func NewReader(seq ringbuffer.Sequencer, barrier *Barrier, available int64) *Reader {
+	return &Reader{
+		seq:       seq,
+		barrier:   barrier,
+		available: available,
+	}
+}
+
+// Wait blocks until the producers have committed seq. Once alerted, it still returns
+// nil for the sequences committed before, unless the reader is closed, and ErrAlerted
+// after them. It returns ErrTimeout if the barrier times out.
+func (this *Reader) Wait(seq int64) error {
+	if this.available >= seq {
+		return nil
+	}
+
+	available, err := this.seq.WaitFor(seq)
+	if err == ErrAlerted {
+		if this.IsClosed() {
+			return ErrAlerted
+		}
+
+		if available, _ = this.barrier.Min(); available < seq {
+			return ErrAlerted
+		}
+	} else if err != nil {
+		return err
+	}
+
+	this.available = available
+	return nil
+}
+
+// Close marks the reader as closed and wakes up a pending Wait, which returns ErrAlerted
+// like all the following ones. It returns false if the reader was already closed.
+func (this *Reader) Close() bool {
+	if !atomic.CompareAndSwapInt32(&this.closed, 0, 1) {
+		return false
+	}
+
+	this.seq.Alert()
+	return true
+}
+
+// IsClosed returns whether Close has been called.
+func (this *Reader) IsClosed() bool {
+	return atomic.LoadInt32(&this.closed) == 1
+}
+
+// Interrupt wakes up a pending Wait without closing the reader, so the sequences
+// already committed can still be waited for.
+func (this *Reader) Interrupt() {
+	this.seq.Alert()
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package sequence
+
+import (
+	"log"
+	"testing"
+	"time"
+)
+
+var _ = log.Ldate
+
+func newReader(t *testing.T) (*Reader, *Consumer, *Producer) {
+	p, err := NewProducer(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewConsumer(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.AddGatingSequence(p)
+
+	return NewReader(c, c.(*Consumer).Barrier(), InitialSequenceValue), c.(*Consumer), p.(*Producer)
+}
+
+func TestReaderWait(t *testing.T) {
+	r, c, p := newReader(t)
+
+	p.Commit(3)
+
+	if err := r.Wait(0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sequences up to 3 were committed at once, so waiting for them doesn't alert
+	c.Alert()
+
+	for seq := int64(1); seq <= 3; seq++ {
+		if err := r.Wait(seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.Wait(4); err != ErrAlerted {
+		t.Fatalf("Expect ErrAlerted, got %v", err)
+	}
+}
+
+func TestReaderInterruptAndClose(t *testing.T) {
+	r, c, p := newReader(t)
+
+	errc := make(chan error, 1)
+
+	go func() {
+		errc <- r.Wait(1)
+	}()
+
+	// The sequence committed before the interruption is still returned
+	time.Sleep(10 * time.Millisecond)
+	p.Commit(1)
+	r.Interrupt()
+
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	if r.IsClosed() {
+		t.Fatal("Expect the reader not to be closed")
+	}
+
+	c.ClearAlert()
+	p.Commit(5)
+
+	if !r.Close() {
+		t.Fatal("Expect the first Close to return true")
+	}
+
+	if r.Close() {
+		t.Fatal("Expect the second Close to return false")
+	}
+
+	// Nothing is read once closed, even if it was committed
+	if err := r.Wait(2); err != ErrAlerted {
+		t.Fatalf("Expect ErrAlerted, got %v", err)
+	}
+}
@@ -26,7 +26,7 @@ func TestSequenceSetAndGet(t *testing.T) {
 	if v, err := seq.Get(); err != nil {
 		t.Fatal(err)
 	} else if v != 1000 {
-		t.Fatalf("Expect v == 1000, got ", v)
+		t.Fatalf("Expect v == 1000, got %d", v)
 	}
 }
 
@@ -38,13 +38,16 @@ func TestSequenceDisruptorStyle(t *testing.T) {
 	pseq := NewSequence()
 	cseq := NewSequence()
 
+	errc := make(chan error, 1)
+
 	go func() {
 		max := int64(0)
 
 		for i := int64(0); i < ringSize*2; i++ {
 			for i >= max {
 				if tmp, err := cseq.Get(); err != nil {
-					t.Fatal(err)
+					errc <- err
+					return
 				} else {
 					max = tmp + ringSize - 2
 				}
@@ -73,6 +76,12 @@ func TestSequenceDisruptorStyle(t *testing.T) {
 			t.Fatalf("Exepect val == %d, got %d", i, val)
 		}
 	}
+
+	select {
+	case err := <-errc:
+		t.Fatal(err)
+	default:
+	}
 }
 
 func BenchmarkSequenceGet(b *testing.B) {
@@ -109,7 +118,8 @@ func BenchmarkSequenceDisruptorStyle(b *testing.B) {
 		for i := int64(0); i < int64(b.N); i++ {
 			for i >= max {
 				if tmp, err := cseq.Get(); err != nil {
-					b.Fatal(err)
+					b.Error(err)
+					return
 				} else {
 					max = tmp + ringSize - 2
 				}
@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"github.com/reducedb/ringbuffer"
 	"log"
-	"sync"
 )
 
 var _ = log.Ldate
@@ -21,8 +20,8 @@ var (
 type sequencer struct {
 	sequence
 
-	gates      []ringbuffer.Sequencer
-	gatesMutex sync.RWMutex
+	// barrier holds the gating sequences and the wait strategy used by Request
+	barrier *Barrier
 
 	bufferSize int
 }
@@ -42,18 +41,48 @@ func (this *sequencer) Request(n int) (int64, error) {
 	return 0, fmt.Errorf("Not implemented")
 }
 
+func (this *sequencer) WaitFor(seq int64) (int64, error) {
+	return 0, fmt.Errorf("Not implemented")
+}
+
 func (this *sequencer) Commit(n int64) error {
 	return this.Set(n)
 }
 
 func (this *sequencer) AddGatingSequence(seq ...ringbuffer.Sequencer) {
-	this.gatesMutex.Lock()
-	defer this.gatesMutex.Unlock()
-	this.gates = append(this.gates, seq...)
+	this.barrier.Add(seq...)
 }
 
 func (this *sequencer) RemoveGatingSequence(seq ringbuffer.Sequencer) {
-	this.gatesMutex.Lock()
-	defer this.gatesMutex.Unlock()
-	// TODO: add removing sequence routine
+	this.barrier.Remove(seq)
+}
+
+// SetWaitStrategy sets what Request does while it waits for the gating sequences. It must
+// be called before the sequencer is used, as it's not synchronized with Request, WaitFor
+// or Alert.
+func (this *sequencer) SetWaitStrategy(w ringbuffer.WaitStrategy) {
+	this.barrier.SetWaitStrategy(w)
+}
+
+// Alert makes the pending and future calls to Request and WaitFor return ErrAlerted,
+// until ClearAlert is called. If the barrier is shared, the other sequencers are alerted
+// as well.
+func (this *sequencer) Alert() {
+	this.barrier.Alert()
+}
+
+func (this *sequencer) ClearAlert() {
+	this.barrier.ClearAlert()
+}
+
+// Barrier returns the barrier holding the gating sequences.
+func (this *sequencer) Barrier() *Barrier {
+	return this.barrier
+}
+
+// SetBarrier replaces the barrier holding the gating sequences, so it can be shared with
+// other sequencers. It must be called before the sequencer is used, or gated by another
+// one, as the barrier is read without synchronization.
+func (this *sequencer) SetBarrier(b *Barrier) {
+	this.barrier = b
 }
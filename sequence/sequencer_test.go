@@ -4,6 +4,9 @@
 package sequence
 
 import (
+	"fmt"
+	"github.com/reducedb/ringbuffer"
+	"github.com/reducedb/ringbuffer/ringtest"
 	"log"
 	"testing"
 )
@@ -51,7 +54,84 @@ func TestSetAndGet(t *testing.T) {
 	if v, err := p.Get(); err != nil {
 		t.Fatal(err)
 	} else if v != 1000 {
-		t.Fatalf("Expect v == 1000, got ", v)
+		t.Fatalf("Expect v == 1000, got %d", v)
+	}
+}
+
+func TestRemoveGatingSequence(t *testing.T) {
+	pseq, err := NewProducer(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cseq, err := NewConsumer(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pseq.AddGatingSequence(cseq)
+
+	// The consumer never moves, so the producer can only fill the buffer once
+	for i := 0; i < 4; i++ {
+		if _, err := pseq.Next(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pseq.RemoveGatingSequence(cseq)
+
+	// Without the gate, the producer can wrap around
+	if seq, err := pseq.Next(1); err != nil {
+		t.Fatal(err)
+	} else if seq != 4 {
+		t.Fatalf("Expect seq == 4, got %d", seq)
+	}
+}
+
+func TestAlert(t *testing.T) {
+	pseq, err := NewProducer(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cseq, err := NewConsumer(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pseq.AddGatingSequence(cseq)
+	pseq.SetWaitStrategy(ringbuffer.NewYieldingWait())
+
+	if _, err := pseq.Next(2); err != nil {
+		t.Fatal(err)
+	}
+
+	// The buffer is full, so the producer waits until it's alerted
+	errc := make(chan error, 1)
+
+	go func() {
+		_, err := pseq.Request(1)
+		errc <- err
+	}()
+
+	pseq.Alert()
+
+	if err := <-errc; err != ErrAlerted {
+		t.Fatalf("Expect ErrAlerted, got %v", err)
+	}
+
+	cseq.Commit(0)
+
+	if _, err := pseq.Request(1); err != ErrAlerted {
+		t.Fatalf("Expect ErrAlerted until the alert is cleared, got %v", err)
+	}
+
+	pseq.ClearAlert()
+
+	if seq, err := pseq.Request(1); err != nil {
+		t.Fatal(err)
+	} else if seq != 2 {
+		t.Fatalf("Expect seq == 2, got %d", seq)
 	}
 }
 
@@ -75,11 +155,14 @@ func Test1ProducerAnd1Consumer(t *testing.T) {
 
 	var count int64 = 100000
 
+	errc := make(chan error, 2)
+
 	// Producer goroutine
 	go func() {
 		for seq, err := pseq.Request(1); seq < count; seq, err = pseq.Request(1) {
 			if err != nil {
-				t.Fatal(err)
+				errc <- err
+				return
 			} else {
 				ring[seq&ringMask] = seq
 				//log.Printf("producer: commit %d\n", seq)
@@ -114,6 +197,123 @@ func Test1ProducerAnd1Consumer(t *testing.T) {
 	if total != count {
 		t.Fatalf("Expected to have read %d items, got %d\n", count, total)
 	}
+
+	select {
+	case err := <-errc:
+		t.Fatal(err)
+	default:
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	pseq, err := NewProducer(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cseq, err := NewConsumer(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pseq.AddGatingSequence(cseq)
+	cseq.AddGatingSequence(pseq)
+
+	if seq, err := pseq.WaitFor(0); err != nil {
+		t.Fatal(err)
+	} else if seq != 3 {
+		t.Fatalf("Expect the producer to claim up to 3, got %d", seq)
+	}
+
+	if _, err := pseq.Next(3); err != nil {
+		t.Fatal(err)
+	}
+
+	// All 3 sequences committed are returned at once
+	if seq, err := cseq.WaitFor(0); err != nil {
+		t.Fatal(err)
+	} else if seq != 2 {
+		t.Fatalf("Expect the consumer to read up to 2, got %d", seq)
+	}
+
+	cseq.Commit(1)
+
+	if seq, err := pseq.WaitFor(4); err != nil {
+		t.Fatal(err)
+	} else if seq != 5 {
+		t.Fatalf("Expect the producer to claim up to 5, got %d", seq)
+	}
+}
+
+func Test1ProducerAnd1ConsumerWaitFor(t *testing.T) {
+	const ringSize = 128
+	var ring [ringSize]int64
+	var ringMask int64 = ringSize - 1
+
+	pseq, err := NewProducer(ringSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cseq, err := NewConsumer(ringSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pseq.AddGatingSequence(cseq)
+	cseq.AddGatingSequence(pseq)
+
+	// Busy spinning would keep the consumer from running on a single CPU
+	pseq.SetWaitStrategy(ringbuffer.NewYieldingWait())
+
+	var count int64 = 100000
+
+	errc := make(chan error, 1)
+
+	// Producer goroutine
+	go func() {
+		for seq, err := pseq.Request(1); seq < count; seq, err = pseq.Request(1) {
+			if err != nil {
+				errc <- err
+				return
+			}
+
+			ring[seq&ringMask] = seq
+			pseq.Commit(seq)
+		}
+	}()
+
+	var total, batches int64
+
+	// Consumer goroutine, reading all the available sequences per wake-up
+	for next := int64(0); next < count; batches++ {
+		available, err := cseq.WaitFor(next)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for ; next <= available && next < count; next++ {
+			if val := ring[next&ringMask]; val != next {
+				t.Fatalf("Expect val == %d, got %d", next, val)
+			}
+
+			total++
+		}
+
+		cseq.Commit(next - 1)
+	}
+
+	if total != count {
+		t.Fatalf("Expected to have read %d items, got %d", count, total)
+	}
+
+	t.Logf("read %d items in %d batches", total, batches)
+
+	select {
+	case err := <-errc:
+		t.Fatal(err)
+	default:
+	}
 }
 
 func Test1ProducerAnd2Consumer(t *testing.T) {
@@ -143,11 +343,14 @@ func Test1ProducerAnd2Consumer(t *testing.T) {
 
 	var count int64 = 1000
 
+	errc := make(chan error, 2)
+
 	// Producer goroutine
 	go func() {
 		for seq, err := pseq.Request(1); seq < count; seq, err = pseq.Request(1) {
 			if err != nil {
-				t.Fatal(err)
+				errc <- err
+				return
 			} else {
 				ring[seq&ringMask] = seq
 				//log.Printf("producer: commit %d\n", seq)
@@ -156,20 +359,26 @@ func Test1ProducerAnd2Consumer(t *testing.T) {
 		}
 	}()
 
+	done := make(chan struct{})
+
 	// Consumer goroutine 1
 	go func() {
+		defer close(done)
+
 		var total int64
 
 		for seq, err := cseq.Request(1); seq < count; seq, err = cseq.Request(1) {
 			if err != nil {
-				t.Fatal(err)
+				errc <- err
+				return
 			} else {
 				val := ring[seq&ringMask]
 				//log.Printf("consumer1: commit %d\n", seq)
 				cseq.Commit(seq)
 
 				if val != seq {
-					t.Fatalf("Expect val == %d, got %d", seq, val)
+					errc <- fmt.Errorf("Expect val == %d, got %d", seq, val)
+					return
 				}
 
 				total++
@@ -181,7 +390,7 @@ func Test1ProducerAnd2Consumer(t *testing.T) {
 		}
 
 		if total != count {
-			t.Fatalf("Expected to have read %d items, got %d\n", count, total)
+			errc <- fmt.Errorf("Expected to have read %d items, got %d", count, total)
 		}
 	}()
 
@@ -211,6 +420,205 @@ func Test1ProducerAnd2Consumer(t *testing.T) {
 	if total != count {
 		t.Fatalf("Expected to have read %d items, got %d\n", count, total)
 	}
+
+	<-done
+
+	select {
+	case err := <-errc:
+		t.Fatal(err)
+	default:
+	}
+}
+
+func TestWrapPointInterleaving(t *testing.T) {
+	const ringSize = 4
+	var ring [ringSize]int64
+	var ringMask int64 = ringSize - 1
+
+	pseq, err := NewProducer(ringSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cseq, err := NewConsumer(ringSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pseq.AddGatingSequence(cseq)
+	cseq.AddGatingSequence(pseq)
+
+	s := ringtest.NewScheduler()
+
+	// The producer yields between writing each slot and committing it
+	s.Go("producer", func(a *ringtest.Actor) error {
+		pseq.SetWaitStrategy(a)
+
+		for i := 0; i < 8; i++ {
+			seq, err := pseq.Request(1)
+			if err != nil {
+				return err
+			}
+
+			ring[seq&ringMask] = seq
+			a.Yield()
+			pseq.Commit(seq)
+		}
+
+		return nil
+	})
+
+	// The consumer yields after committing each slot
+	s.Go("consumer", func(a *ringtest.Actor) error {
+		cseq.SetWaitStrategy(a)
+
+		for i := 0; i < 8; i++ {
+			seq, err := cseq.Request(1)
+			if err != nil {
+				return err
+			}
+
+			if val := ring[seq&ringMask]; val != seq {
+				return fmt.Errorf("Expect val == %d, got %d", seq, val)
+			}
+
+			cseq.Commit(seq)
+			a.Yield()
+		}
+
+		return nil
+	})
+
+	// Fill the ring, up to the uncommitted slot 3
+	if err := s.Run("producer", "producer", "producer", "producer"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Slot 4 wraps around onto slot 0, which has not been consumed
+	for i := 0; i < 2; i++ {
+		if state, err := s.Step("producer"); err != nil {
+			t.Fatal(err)
+		} else if state != ringtest.Waiting {
+			t.Fatalf("Expect the producer to wait at the wrap point, got %v", state)
+		}
+	}
+
+	if state, err := s.Step("consumer"); err != nil {
+		t.Fatal(err)
+	} else if state != ringtest.Yielded {
+		t.Fatalf("Expect the consumer to consume slot 0, got %v", state)
+	}
+
+	if state, err := s.Step("producer"); err != nil {
+		t.Fatal(err)
+	} else if state != ringtest.Yielded || ring[0] != 4 {
+		t.Fatalf("Expect the producer to write slot 4, got %v, ring[0] == %d", state, ring[0])
+	}
+
+	// The producer's cachedGate is now 0, so slot 5 waits for the consumer again
+	if state, err := s.Step("producer"); err != nil {
+		t.Fatal(err)
+	} else if state != ringtest.Waiting {
+		t.Fatalf("Expect the producer to wait on its stale gate, got %v", state)
+	}
+
+	if err := s.RunRandom(1, 1000); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRandomInterleavings(t *testing.T) {
+	const ringSize = 8
+	const count = 64
+
+	for seed := int64(0); seed < 50; seed++ {
+		var ring [ringSize]int64
+		var ringMask int64 = ringSize - 1
+
+		pseq, err := NewProducer(ringSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cseqs := make([]ringbuffer.Sequencer, 2)
+
+		for i := range cseqs {
+			if cseqs[i], err = NewConsumer(ringSize); err != nil {
+				t.Fatal(err)
+			}
+
+			pseq.AddGatingSequence(cseqs[i])
+			cseqs[i].AddGatingSequence(pseq)
+		}
+
+		s := ringtest.NewScheduler()
+
+		// Last slot requested but not yet committed by the producer
+		requested := int64(InitialSequenceValue)
+
+		s.Go("producer", func(a *ringtest.Actor) error {
+			pseq.SetWaitStrategy(a)
+
+			for i, cursor := 0, int64(-1); cursor < count-1; i++ {
+				// Request 1 to 3 slots at a time, so the wrap point moves around
+				seq, err := pseq.Request(i%3 + 1)
+				if err != nil {
+					return err
+				}
+
+				requested = seq
+
+				for i := cursor + 1; i <= seq; i++ {
+					ring[i&ringMask] = i
+					a.Yield()
+				}
+
+				pseq.Commit(seq)
+				cursor = seq
+				a.Yield()
+			}
+
+			return nil
+		})
+
+		for i, cseq := range cseqs {
+			cseq := cseq
+
+			s.Go(fmt.Sprintf("consumer%d", i), func(a *ringtest.Actor) error {
+				cseq.SetWaitStrategy(a)
+
+				for seq := int64(0); seq < count; seq++ {
+					if _, err := cseq.Request(1); err != nil {
+						return err
+					}
+
+					if val := ring[seq&ringMask]; val != seq {
+						return fmt.Errorf("Expect val == %d, got %d", seq, val)
+					}
+
+					a.Yield()
+					cseq.Commit(seq)
+				}
+
+				return nil
+			})
+		}
+
+		// The producer must never claim a slot that a consumer has yet to read
+		s.Check(func() error {
+			for _, cseq := range cseqs {
+				if cursor, _ := cseq.Get(); requested-ringSize > cursor {
+					return fmt.Errorf("producer requested %d with consumer at %d", requested, cursor)
+				}
+			}
+
+			return nil
+		})
+
+		if err := s.RunRandom(seed, 100000); err != nil {
+			t.Fatalf("seed %d: %v\ntrace: %v", seed, err, s.Trace)
+		}
+	}
 }
 
 func Benchmark1ProducerAnd1Consumer(b *testing.B) {
@@ -235,7 +643,8 @@ func Benchmark1ProducerAnd1Consumer(b *testing.B) {
 	go func() {
 		for seq, err := pseq.Request(1); seq < int64(b.N); seq, err = pseq.Request(1) {
 			if err != nil {
-				b.Fatal(err)
+				b.Error(err)
+				return
 			} else {
 				ring[seq&ringMask] = seq
 				//log.Printf("producer: commit %d\n", seq)
@@ -274,3 +683,56 @@ func Benchmark1ProducerAnd1Consumer(b *testing.B) {
 		b.Fatalf("Expected to have read %d items, got %d\n", int64(b.N), total)
 	}
 }
+
+func Benchmark1ProducerAnd1ConsumerWaitFor(b *testing.B) {
+	const ringSize = 128
+	var ring [ringSize]int64
+	var ringMask int64 = ringSize - 1
+
+	pseq, err := NewProducer(ringSize)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	cseq, err := NewConsumer(ringSize)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	pseq.AddGatingSequence(cseq)
+	cseq.AddGatingSequence(pseq)
+	pseq.SetWaitStrategy(ringbuffer.NewYieldingWait())
+
+	count := int64(b.N)
+
+	// Producer goroutine
+	go func() {
+		for seq, err := pseq.Request(1); seq < count; seq, err = pseq.Request(1) {
+			if err != nil {
+				b.Error(err)
+				return
+			}
+
+			ring[seq&ringMask] = seq
+			pseq.Commit(seq)
+		}
+	}()
+
+	// Consumer goroutine
+	for next := int64(0); next < count; {
+		available, err := cseq.WaitFor(next)
+		if err != nil {
+			b.Error(err)
+			return
+		}
+
+		for ; next <= available && next < count; next++ {
+			if val := ring[next&ringMask]; val != next {
+				b.Errorf("Expect val == %d, got %d", next, val)
+				return
+			}
+		}
+
+		cseq.Commit(next - 1)
+	}
+}
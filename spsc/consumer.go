@@ -0,0 +1,150 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package spsc
+
+import (
+	"github.com/reducedb/ringbuffer"
+	"log"
+	"sync/atomic"
+)
+
+var _ = log.Ldate
+
+// Consumer reads entries from the ring buffer. Except for Close, it must only be used
+// from one goroutine. Close may be called from another goroutine to interrupt a pending
+// Get, but the next consumer must not be created until that Get has returned, as it
+// commits to the same head.
+type Consumer struct {
+	ring *ring
+
+	// cached is the consumer's copy of the tail. It's kept here rather than in the ring,
+	// so that it belongs to a single consumer.
+	cached int64
+
+	// read is the sequence of the last slot read. It's committed by the following call
+	// to Get, so that the data cannot be overwritten while the caller is still using it.
+	read    int64
+	pending bool
+
+	// tmpbuf holds the data of entries that wrapped around the end of the buffer
+	tmpbuf []byte
+
+	closed int32
+}
+
+var _ ringbuffer.Consumer = (*Consumer)(nil)
+
+// NewConsumer creates the consumer, which starts with the next entry put.
+func (this *ring) NewConsumer() (ringbuffer.Consumer, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.consumer != nil {
+		return nil, ErrMaxConsumerCountExceeded
+	}
+
+	tail := atomic.LoadInt64(&this.tail.value)
+
+	atomic.StoreInt64(&this.head.value, tail)
+	atomic.StoreInt32(&this.attached, 1)
+
+	this.consumer = &Consumer{
+		ring:   this,
+		cached: tail,
+		read:   tail,
+	}
+
+	return this.consumer, nil
+}
+
+// Close detaches the consumer from the ring buffer so the producer no longer waits for
+// it, and another consumer can be created. Pending and future calls to Get return
+// ErrClosed.
+func (this *Consumer) Close() error {
+	if !atomic.CompareAndSwapInt32(&this.closed, 0, 1) {
+		return nil
+	}
+
+	r := this.ring
+
+	r.mutex.Lock()
+	atomic.StoreInt32(&r.attached, 0)
+	r.consumer = nil
+	r.mutex.Unlock()
+
+	r.waitStrategy.Signal()
+
+	return nil
+}
+
+// Get returns the data of the next entry as a []byte. The data is only valid until the
+// next call.
+func (this *Consumer) Get() (interface{}, error) {
+	data, err := this.GetBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// GetBytes is the same as Get, without the conversion to interface{}.
+func (this *Consumer) GetBytes() ([]byte, error) {
+	if atomic.LoadInt32(&this.closed) == 1 {
+		return nil, ErrClosed
+	}
+
+	r := this.ring
+
+	if this.pending {
+		atomic.StoreInt64(&r.head.value, this.read)
+		r.waitStrategy.Signal()
+		this.pending = false
+	}
+
+	start := this.read + 1
+
+	if start > this.cached {
+		if err := this.waitTail(start); err != nil {
+			return nil, err
+		}
+	}
+
+	data := r.get(start, &this.tmpbuf)
+
+	// The producer publishes all the slots of an entry at once
+	this.read = start + int64(1+(len(data)+SlotOverhead-1)/r.slotSize) - 1
+	this.pending = true
+
+	return data, nil
+}
+
+// waitTail blocks until the producer has published seq. If the consumer or the buffer is
+// closed and seq will never be published, ErrClosed is returned.
+func (this *Consumer) waitTail(seq int64) error {
+	r := this.ring
+
+	for retries := 1; ; retries++ {
+		if atomic.LoadInt32(&this.closed) == 1 {
+			return ErrClosed
+		}
+
+		if tail := atomic.LoadInt64(&r.tail.value); tail >= seq {
+			this.cached = tail
+			return nil
+		}
+
+		if r.isClosed() {
+			// A Put may have been published right before the buffer was closed
+			if tail := atomic.LoadInt64(&r.tail.value); tail >= seq {
+				this.cached = tail
+				return nil
+			}
+
+			return ErrClosed
+		}
+
+		r.waitStrategy.Wait(retries)
+	}
+}
@@ -0,0 +1,20 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package spsc
+
+import (
+	"github.com/reducedb/ringbuffer"
+)
+
+// Option configures the ring buffer created by New.
+type Option func(*ring) error
+
+// WithWaitStrategy sets what the producers and consumers do while they wait for each
+// other. The default is ringbuffer.NewYieldingWait().
+func WithWaitStrategy(w ringbuffer.WaitStrategy) Option {
+	return func(this *ring) error {
+		this.waitStrategy = w
+		return nil
+	}
+}
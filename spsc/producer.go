@@ -0,0 +1,101 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package spsc
+
+import (
+	"github.com/reducedb/ringbuffer"
+	"log"
+	"sync/atomic"
+)
+
+var _ = log.Ldate
+
+// Producer writes entries to the ring buffer. It must only be used from one goroutine.
+type Producer struct {
+	ring *ring
+}
+
+var _ ringbuffer.Producer = (*Producer)(nil)
+
+func (this *ring) NewProducer() (ringbuffer.Producer, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.producer != nil {
+		return nil, ErrMaxProducerCountExceeded
+	}
+
+	this.producer = &Producer{ring: this}
+
+	return this.producer, nil
+}
+
+// Close marks the producer as done, which closes the ring buffer.
+func (this *Producer) Close() error {
+	return this.ring.Close()
+}
+
+// Put writes the data, which must be a []byte, to the ring buffer. Returns the number
+// of slots used.
+func (this *Producer) Put(data interface{}) (int, error) {
+	src, ok := data.([]byte)
+	if !ok {
+		return 0, ErrDataInvalid
+	}
+
+	return this.PutBytes(src)
+}
+
+// PutBytes is the same as Put, without the conversion from interface{}.
+func (this *Producer) PutBytes(data []byte) (int, error) {
+	r := this.ring
+
+	needed, err := r.slotsNeeded(len(data))
+	if err != nil {
+		return 0, err
+	}
+
+	if r.isClosed() {
+		return 0, ErrClosed
+	}
+
+	// Only the producer writes the tail, so it doesn't need to be loaded atomically
+	next := r.tail.value + int64(needed)
+
+	if wrapPoint := next - int64(r.slotCount); wrapPoint > r.tail.cached {
+		if err := r.waitHead(wrapPoint); err != nil {
+			return 0, err
+		}
+	}
+
+	r.put(r.tail.value+1, data)
+
+	atomic.StoreInt64(&r.tail.value, next)
+	r.waitStrategy.Signal()
+
+	return needed, nil
+}
+
+// waitHead blocks until the consumer has committed seq, or there is no consumer. If the
+// buffer is closed while waiting, ErrClosed is returned.
+func (this *ring) waitHead(seq int64) error {
+	for retries := 1; ; retries++ {
+		if atomic.LoadInt32(&this.attached) == 0 {
+			// Nothing to wait for. A consumer attaching later starts after the tail.
+			this.tail.cached = seq
+			return nil
+		}
+
+		if head := atomic.LoadInt64(&this.head.value); head >= seq {
+			this.tail.cached = head
+			return nil
+		}
+
+		if this.isClosed() {
+			return ErrClosed
+		}
+
+		this.waitStrategy.Wait(retries)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+// Spsc implements a ring buffer for exactly one producer and one consumer. It stores
+// the entries the same way as bytebuffer, but instead of going through the
+// ringbuffer.Sequencer interfaces and their gating sequences, the producer and the
+// consumer read each other's cursor directly, and cache it on their own cache line so
+// they only touch the other side's when they run out of room or entries.
+//
+// New returns a ringbuffer.RingBuffer like the other storage engines. The producers
+// and consumers it returns are *Producer and *Consumer, whose methods can be called
+// directly to also avoid the interface dispatch.
+package spsc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/reducedb/ringbuffer"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+var _ = log.Ldate
+
+const (
+	// Number of overhead bytes for each data item
+	SlotOverhead = 2
+
+	// Maximum data size, -SlotOverhead because we are using 2 bytes to store the size of the data
+	MaxDataSize = 64*1024 - SlotOverhead
+
+	MaxProducerCount = 1
+	MaxConsumerCount = 1
+)
+
+var (
+	ErrDataInvalid              = fmt.Errorf("spsc: Data Invalid")
+	ErrDataExceedsMaxSize       = fmt.Errorf("spsc: Data Size Exceeds MaxDataSize")
+	ErrDataExceedsMaxSlots      = fmt.Errorf("spsc: Data Size Exceeds Slot Count")
+	ErrSlotSizeTooSmall         = fmt.Errorf("spsc: Slot Size Is Too Small")
+	ErrNotPowerOfTwo            = fmt.Errorf("spsc: Slot Count Must Be Power of Two")
+	ErrMaxProducerCountExceeded = fmt.Errorf("spsc: This ringbuffer only allows %d producer(s)", MaxProducerCount)
+	ErrMaxConsumerCountExceeded = fmt.Errorf("spsc: This ringbuffer only allows %d consumer(s)", MaxConsumerCount)
+	ErrClosed                   = fmt.Errorf("spsc: Ring Buffer Closed")
+)
+
+// RingBuffer is implemented by the ring buffers returned from New.
+type RingBuffer interface {
+	ringbuffer.RingBuffer
+
+	// Close stops the producer from writing more entries. The consumer will continue to
+	// read the remaining entries, after which ErrClosed is returned.
+	Close() error
+}
+
+// To avoid false sharing, each cursor is padded to 64 bytes, which is the most common
+// cache line size. value is the cursor itself, and cached, for the tail, is the
+// producer's copy of the head. The consumer keeps its copy of the tail in Consumer.
+type cursor struct {
+	value, cached, p2, p3, p4, p5, p6, p7 int64
+}
+
+type ring struct {
+	// tail is the last sequence published by the producer, and head the last sequence
+	// committed by the consumer. p0 keeps them off the cache line of whatever precedes
+	// the ring in memory.
+	p0   cursor
+	tail cursor
+	head cursor
+
+	// attached is 1 while a consumer holds back the producer
+	attached int32
+	closed   int32
+
+	buffer     []byte
+	slotSize   int
+	slotCount  int
+	slotMask   int64
+	bufferSize int64
+
+	waitStrategy ringbuffer.WaitStrategy
+
+	producer *Producer
+	consumer *Consumer
+	mutex    sync.Mutex
+}
+
+var _ RingBuffer = (*ring)(nil)
+
+// New creates a ring buffer of slotCount slots, which must be a power of two, each
+// holding slotSize bytes of data.
+func New(slotSize, slotCount int, opts ...Option) (ringbuffer.RingBuffer, error) {
+	if slotSize < 1 {
+		return nil, ErrSlotSizeTooSmall
+	}
+
+	if !ringbuffer.PowerOfTwo(slotCount) {
+		return nil, ErrNotPowerOfTwo
+	}
+
+	slotSize += SlotOverhead
+
+	d := &ring{
+		buffer:       make([]byte, slotSize*slotCount),
+		slotSize:     slotSize,
+		slotCount:    slotCount,
+		slotMask:     int64(slotCount - 1),
+		bufferSize:   int64(slotSize * slotCount),
+		waitStrategy: ringbuffer.NewYieldingWait(),
+	}
+
+	d.tail.value, d.tail.cached = -1, -1
+	d.head.value = -1
+
+	for _, opt := range opts {
+		if err := opt(d); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+func (this *ring) Close() error {
+	atomic.StoreInt32(&this.closed, 1)
+	this.waitStrategy.Signal()
+	return nil
+}
+
+func (this *ring) isClosed() bool {
+	return atomic.LoadInt32(&this.closed) == 1
+}
+
+// slotsNeeded returns the number of slots required for size bytes of data
+func (this *ring) slotsNeeded(size int) (int, error) {
+	if size > MaxDataSize {
+		return 0, ErrDataExceedsMaxSize
+	}
+
+	needed := 1 + (size+SlotOverhead-1)/this.slotSize
+
+	if needed > this.slotCount {
+		return 0, ErrDataExceedsMaxSlots
+	}
+
+	return needed, nil
+}
+
+// put writes data into the slots starting at seq, wrapping around the end of the buffer
+func (this *ring) put(seq int64, data []byte) {
+	index := (seq & this.slotMask) * int64(this.slotSize)
+
+	binary.LittleEndian.PutUint16(this.buffer[index:], uint16(len(data)))
+	index += SlotOverhead
+
+	if l := copy(this.buffer[index:], data); l < len(data) {
+		copy(this.buffer, data[l:])
+	}
+}
+
+// get returns the data in the slots starting at seq. If it wraps around the end of the
+// buffer, it is copied into scratch, which is grown as needed.
+func (this *ring) get(seq int64, scratch *[]byte) []byte {
+	index := (seq & this.slotMask) * int64(this.slotSize)
+
+	n := int64(binary.LittleEndian.Uint16(this.buffer[index:]))
+	index += SlotOverhead
+
+	if index+n <= this.bufferSize {
+		return this.buffer[index : index+n]
+	}
+
+	if int(n) > len(*scratch) {
+		*scratch = make([]byte, n)
+	}
+
+	tmpbuf := (*scratch)[:n]
+	l := copy(tmpbuf, this.buffer[index:])
+	copy(tmpbuf[l:], this.buffer)
+
+	return tmpbuf
+}
@@ -0,0 +1,263 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package spsc
+
+import (
+	"bytes"
+	"github.com/reducedb/ringbuffer"
+	"github.com/reducedb/ringbuffer/bytebuffer"
+	"github.com/reducedb/ringbuffer/ringtest"
+	"log"
+	"testing"
+)
+
+var _ = log.Ldate
+
+func TestNew(t *testing.T) {
+	if _, err := New(0, 4); err != ErrSlotSizeTooSmall {
+		t.Fatalf("Expect ErrSlotSizeTooSmall, got %v", err)
+	}
+
+	if _, err := New(4, 3); err != ErrNotPowerOfTwo {
+		t.Fatalf("Expect ErrNotPowerOfTwo, got %v", err)
+	}
+
+	r, err := New(4, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.NewProducer(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.NewProducer(); err != ErrMaxProducerCountExceeded {
+		t.Fatalf("Expect ErrMaxProducerCountExceeded, got %v", err)
+	}
+
+	c, err := r.NewConsumer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.NewConsumer(); err != ErrMaxConsumerCountExceeded {
+		t.Fatalf("Expect ErrMaxConsumerCountExceeded, got %v", err)
+	}
+
+	c.(*Consumer).Close()
+
+	if _, err := r.NewConsumer(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	ringtest.Conformance(t, ringtest.Engine{
+		New: func() (ringbuffer.RingBuffer, error) {
+			return New(4, 8)
+		},
+		Entry: func(i int) interface{} {
+			return bytes.Repeat([]byte{byte(i)}, i%7)
+		},
+		Check: func(v interface{}, i int) bool {
+			return bytes.Equal(v.([]byte), bytes.Repeat([]byte{byte(i)}, i%7))
+		},
+		ErrClosed: ErrClosed,
+	})
+}
+
+func TestPutGet(t *testing.T) {
+	r, err := New(4, 8)
+	p, c := ringtest.Open(t, r, err)
+
+	if _, err := p.Put("string"); err != ErrDataInvalid {
+		t.Fatalf("Expect ErrDataInvalid, got %v", err)
+	}
+
+	if _, err := p.Put(make([]byte, 47)); err != ErrDataExceedsMaxSlots {
+		t.Fatalf("Expect ErrDataExceedsMaxSlots, got %v", err)
+	}
+
+	// Slots of 6 bytes, so the entries of 10 bytes take 2 slots, and the 5th one wraps
+	// around the end of the buffer
+	for i, n := range []int{4, 10, 10, 10, 10, 10, 22} {
+		data := bytes.Repeat([]byte{byte(i)}, n)
+
+		if needed, err := p.Put(data); err != nil {
+			t.Fatal(err)
+		} else if expected := 1 + (n+1)/6; needed != expected {
+			t.Fatalf("Expect %d slots, got %d", expected, needed)
+		}
+
+		out, err := c.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(out.([]byte), data) {
+			t.Fatalf("Expect %v, got %v", data, out)
+		}
+	}
+}
+
+func TestConcurrentWrap(t *testing.T) {
+	r, err := New(8, 16)
+	rp, rc := ringtest.Open(t, r, err)
+	p, c := rp.(*Producer), rc.(*Consumer)
+
+	const count = 10000
+
+	errc := make(chan error, 1)
+
+	// Entries of up to 5 slots, most of which straddle the end of the buffer at some point
+	go func() {
+		for i := 0; i < count; i++ {
+			if _, err := p.PutBytes(bytes.Repeat([]byte{byte(i)}, i%47)); err != nil {
+				errc <- err
+				return
+			}
+		}
+
+		errc <- p.Close()
+	}()
+
+	copied := 0
+
+	for i := 0; i < count; i++ {
+		out, err := c.GetBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(out, bytes.Repeat([]byte{byte(i)}, i%47)) {
+			t.Fatalf("Entry %d: bytes not the same", i)
+		}
+
+		if len(out) > 0 && len(c.tmpbuf) > 0 && &out[0] == &c.tmpbuf[0] {
+			copied++
+		}
+	}
+
+	if copied == 0 {
+		t.Fatal("Expect the wrapped entries to be copied")
+	}
+
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNextConsumer(t *testing.T) {
+	r, err := New(4, 2)
+	p, c := ringtest.Open(t, r, err)
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.Put([]byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := c.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The entry left unread is skipped, and the next consumer starts with the next
+	// entry, with its own copy of the tail
+	c.(*Consumer).Close()
+
+	c2, err := r.NewConsumer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Put([]byte("next")); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := c2.Get(); err != nil {
+		t.Fatal(err)
+	} else if string(out.([]byte)) != "next" {
+		t.Fatalf("Expect the next entry, got %q", out)
+	}
+}
+
+func benchmarkRing(b *testing.B, r ringbuffer.RingBuffer) {
+	c, err := r.NewConsumer()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	data := make([]byte, 64)
+	n := b.N
+
+	b.SetBytes(int64(len(data)))
+
+	go func() {
+		for i := 0; i < n; i++ {
+			if _, err := p.Put(data); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		if _, err := c.Get(); err != nil {
+			b.Error(err)
+			return
+		}
+	}
+}
+
+func BenchmarkSPSC(b *testing.B) {
+	r, err := New(64, 1024)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	benchmarkRing(b, r)
+}
+
+// BenchmarkSPSCDirect calls the concrete methods, without the interface dispatch
+func BenchmarkSPSCDirect(b *testing.B) {
+	r, err := New(64, 1024)
+	rp, rc := ringtest.Open(b, r, err)
+	p, c := rp.(*Producer), rc.(*Consumer)
+
+	data := make([]byte, 64)
+	n := b.N
+
+	b.SetBytes(int64(len(data)))
+
+	go func() {
+		for i := 0; i < n; i++ {
+			if _, err := p.PutBytes(data); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		if _, err := c.GetBytes(); err != nil {
+			b.Error(err)
+			return
+		}
+	}
+}
+
+// BenchmarkByteBuffer is the same as BenchmarkSPSC through the general path
+func BenchmarkByteBuffer(b *testing.B) {
+	r, err := bytebuffer.New(64, 1024)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	benchmarkRing(b, r)
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package varbuffer
+
+import (
+	"github.com/reducedb/ringbuffer"
+	"github.com/reducedb/ringbuffer/sequence"
+	"log"
+)
+
+var _ = log.Ldate
+
+// Consumer is implemented by the consumers returned from NewConsumer.
+type Consumer interface {
+	ringbuffer.Consumer
+
+	// Close lets the producer overwrite the records the consumer hasn't read yet.
+	// Pending and future calls to Get return ErrClosed.
+	Close() error
+}
+
+type consumer struct {
+	buffer *varBuffer
+	seq    ringbuffer.Sequencer
+	reader *sequence.Reader
+
+	// read is the sequence of the last byte read. The record ending at read is committed
+	// by the following call to Get, so that its data cannot be overwritten while the
+	// caller is still using it.
+	read    int64
+	pending bool
+}
+
+var _ Consumer = (*consumer)(nil)
+
+// NewConsumer creates a consumer that starts with the next record put.
+func (this *varBuffer) NewConsumer() (ringbuffer.Consumer, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	seq, err := sequence.NewConsumer(int(this.size))
+	if err != nil {
+		return nil, err
+	}
+
+	seq.SetWaitStrategy(this.waitStrategy)
+
+	// The producer doesn't take the mutex, so it must be gated before the consumer picks
+	// where it starts. Until it sees the new gate, it can't claim more than a lap past
+	// its cursor, so the records after the cursor read below are left alone.
+	seq.Set(this.publishedLocked())
+
+	for _, p := range this.producers {
+		p.seq.AddGatingSequence(seq)
+	}
+
+	// The records before the producer's cursor may have been overwritten already, and
+	// the consumer couldn't find where the next one starts
+	published := this.publishedLocked()
+	seq.Set(published)
+	this.waitStrategy.Signal()
+
+	for _, p := range this.producers {
+		seq.AddGatingSequence(p.seq)
+	}
+
+	// Get would otherwise wait for the next Put forever
+	if this.isClosed() {
+		seq.Alert()
+	}
+
+	c := &consumer{
+		buffer: this,
+		seq:    seq,
+		reader: sequence.NewReader(seq, seq.(*sequence.Consumer).Barrier(), published),
+		read:   published,
+	}
+
+	this.consumers = append(this.consumers, c)
+
+	return c, nil
+}
+
+// removeConsumer stops the producer from waiting for c before reusing the bytes of the
+// records it has read
+func (this *varBuffer) removeConsumer(c *consumer) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for i, v := range this.consumers {
+		if v == c {
+			this.consumers = append(this.consumers[:i], this.consumers[i+1:]...)
+			break
+		}
+	}
+
+	for _, p := range this.producers {
+		p.seq.RemoveGatingSequence(c.seq)
+	}
+}
+
+func (this *consumer) Close() error {
+	if !this.reader.Close() {
+		return nil
+	}
+
+	this.buffer.removeConsumer(this)
+
+	// The producer may be waiting for the bytes this consumer held
+	this.buffer.waitStrategy.Signal()
+
+	return nil
+}
+
+// Get returns the data of the next record as a []byte. The data points into the ring
+// buffer and is only valid until the next call.
+func (this *consumer) Get() (interface{}, error) {
+	if this.reader.IsClosed() {
+		return nil, ErrClosed
+	}
+
+	if this.pending {
+		this.seq.Commit(this.read)
+		this.buffer.waitStrategy.Signal()
+		this.pending = false
+	}
+
+	for {
+		start := this.read + 1
+
+		// The producer commits each record at once, so the whole record is available
+		// as soon as its header is
+		if err := this.reader.Wait(start + RecordHeaderSize - 1); err == sequence.ErrAlerted {
+			return nil, ErrClosed
+		} else if err != nil {
+			return nil, err
+		}
+
+		data, padding, err := this.buffer.getRecord(start)
+		if err != nil {
+			return nil, err
+		}
+
+		this.read = start + int64(RecordSize(len(data))) - 1
+
+		if !padding {
+			this.pending = true
+			return data, nil
+		}
+
+		// The padding is released right away, since the next record may need its bytes
+		this.seq.Commit(this.read)
+		this.buffer.waitStrategy.Signal()
+	}
+}
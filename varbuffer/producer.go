@@ -0,0 +1,127 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package varbuffer
+
+import (
+	"github.com/reducedb/ringbuffer"
+	"github.com/reducedb/ringbuffer/sequence"
+	"log"
+)
+
+var _ = log.Ldate
+
+// Producer is implemented by the producers returned from NewProducer.
+type Producer interface {
+	ringbuffer.Producer
+
+	// Close marks the producer as done, which closes the ring buffer.
+	Close() error
+}
+
+type producer struct {
+	buffer *varBuffer
+	seq    ringbuffer.Sequencer
+}
+
+var _ Producer = (*producer)(nil)
+
+func (this *varBuffer) NewProducer() (ringbuffer.Producer, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if len(this.producers) >= MaxProducerCount {
+		return nil, ErrMaxProducerCountExceeded
+	}
+
+	seq, err := sequence.NewProducer(int(this.size))
+	if err != nil {
+		return nil, err
+	}
+
+	seq.SetWaitStrategy(this.waitStrategy)
+
+	p := &producer{
+		buffer: this,
+		seq:    seq,
+	}
+
+	this.producers = append(this.producers, p)
+
+	for _, c := range this.consumers {
+		p.seq.AddGatingSequence(c.seq)
+		c.seq.AddGatingSequence(p.seq)
+	}
+
+	return p, nil
+}
+
+func (this *producer) Close() error {
+	return this.buffer.Close()
+}
+
+// Put writes the data, which must be a []byte, to the ring buffer. Returns the number of
+// bytes used by the record, which is RecordSize(len(data)), plus the padding skipped at
+// the end of the buffer if the record didn't fit there.
+func (this *producer) Put(data interface{}) (int, error) {
+	src, ok := data.([]byte)
+	if !ok {
+		return 0, ErrDataInvalid
+	}
+
+	if len(src) > this.buffer.MaxDataSize() {
+		return 0, ErrDataExceedsMaxSize
+	}
+
+	if this.buffer.isClosed() {
+		return 0, ErrClosed
+	}
+
+	needed := RecordSize(len(src))
+
+	pad, err := this.skip(needed)
+	if err != nil {
+		return 0, err
+	}
+
+	seq, err := this.seq.Request(needed)
+	if err == sequence.ErrAlerted {
+		return 0, ErrClosed
+	} else if err != nil {
+		return 0, err
+	}
+
+	this.buffer.putRecord(seq+1-int64(needed), 0, src)
+	this.seq.Commit(seq)
+	this.buffer.waitStrategy.Signal()
+
+	return pad + needed, nil
+}
+
+// skip puts a padding record up to the end of the buffer if a record of needed bytes
+// would wrap around. The padding is committed on its own, so that the producer never
+// requests more bytes than the buffer holds. It returns the number of bytes skipped.
+func (this *producer) skip(needed int) (int, error) {
+	cursor, err := this.seq.Get()
+	if err != nil {
+		return 0, err
+	}
+
+	pad := this.buffer.padding(cursor+1, needed)
+	if pad == 0 {
+		return 0, nil
+	}
+
+	seq, err := this.seq.Request(pad)
+	if err == sequence.ErrAlerted {
+		return 0, ErrClosed
+	} else if err != nil {
+		return 0, err
+	}
+
+	this.buffer.putPadding(cursor + 1)
+	this.seq.Commit(seq)
+	this.buffer.waitStrategy.Signal()
+
+	return pad, nil
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+// Varbuffer implements a ring buffer storage engine for variable length data. Unlike
+// bytebuffer, which rounds every entry up to whole slots, the sequences of a varbuffer
+// count bytes. Each entry is stored as a record made of an 8 byte header followed by
+// the data, padded to a multiple of 8 bytes, so entries of very different sizes share
+// the buffer without wasting space.
+//
+// Records never wrap around the end of the buffer. If a record doesn't fit in the bytes
+// left before the end, the producer fills them with a padding record, which the consumers
+// skip, and puts the record at the start of the buffer. The data returned by Get is
+// therefore never copied.
+package varbuffer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/reducedb/ringbuffer"
+	"github.com/reducedb/ringbuffer/sequence"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+var _ = log.Ldate
+
+const (
+	// Number of bytes in front of the data of each record. The layout is
+	//
+	//   data size (4) | flags (4)
+	RecordHeaderSize = 8
+
+	// Records start at multiples of RecordAlignment bytes
+	RecordAlignment = 8
+
+	// Minimum buffer size, enough for a record of up to 8 bytes of data
+	MinBufferSize = 2 * RecordAlignment
+
+	MaxProducerCount = 1
+
+	// flagPadding marks a record with no data that fills the buffer up to its end
+	flagPadding = 0x0001
+)
+
+var (
+	ErrDataInvalid              = fmt.Errorf("varbuffer: Data Invalid")
+	ErrDataExceedsMaxSize       = fmt.Errorf("varbuffer: Data Size Exceeds MaxDataSize")
+	ErrInvalidBufferSize        = fmt.Errorf("varbuffer: Buffer Size Must Be Power of Two and At Least %d", MinBufferSize)
+	ErrMaxProducerCountExceeded = fmt.Errorf("varbuffer: This ringbuffer only allows %d producer(s)", MaxProducerCount)
+	ErrClosed                   = fmt.Errorf("varbuffer: Ring Buffer Closed")
+	ErrRecordInvalid            = fmt.Errorf("varbuffer: Record Size Exceeds the Buffer")
+)
+
+// RingBuffer is implemented by the ring buffers returned from New.
+type RingBuffer interface {
+	ringbuffer.RingBuffer
+
+	// Close stops the producers from writing more records. Consumers will continue to
+	// read the remaining records, after which ErrClosed is returned.
+	Close() error
+
+	// Size returns the number of bytes in the buffer
+	Size() int
+
+	// MaxDataSize returns the size of the largest data that can be put
+	MaxDataSize() int
+}
+
+type varBuffer struct {
+	buffer []byte
+
+	size int64
+	mask int64
+
+	waitStrategy ringbuffer.WaitStrategy
+
+	producers []*producer
+	consumers []*consumer
+	mutex     sync.RWMutex
+
+	closed int32
+}
+
+var _ RingBuffer = (*varBuffer)(nil)
+
+// New creates a ring buffer of size bytes, which must be a power of two.
+func New(size int, opts ...Option) (ringbuffer.RingBuffer, error) {
+	if size < MinBufferSize || !ringbuffer.PowerOfTwo(size) {
+		return nil, ErrInvalidBufferSize
+	}
+
+	d := &varBuffer{
+		buffer:       make([]byte, size),
+		size:         int64(size),
+		mask:         int64(size - 1),
+		waitStrategy: ringbuffer.NewYieldingWait(),
+	}
+
+	for _, opt := range opts {
+		if err := opt(d); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+func (this *varBuffer) Close() error {
+	atomic.StoreInt32(&this.closed, 1)
+
+	// Wake up the producers waiting for room, and the consumers waiting for records
+	this.mutex.RLock()
+	for _, p := range this.producers {
+		p.seq.Alert()
+	}
+
+	for _, c := range this.consumers {
+		c.reader.Interrupt()
+	}
+	this.mutex.RUnlock()
+
+	this.waitStrategy.Signal()
+	return nil
+}
+
+func (this *varBuffer) isClosed() bool {
+	return atomic.LoadInt32(&this.closed) == 1
+}
+
+func (this *varBuffer) Size() int {
+	return int(this.size)
+}
+
+func (this *varBuffer) MaxDataSize() int {
+	return int(this.size) - RecordHeaderSize
+}
+
+// RecordSize returns the number of bytes used by a record of size bytes of data,
+// including the header and the padding.
+func RecordSize(size int) int {
+	return (RecordHeaderSize + size + RecordAlignment - 1) &^ (RecordAlignment - 1)
+}
+
+// publishedLocked returns the last sequence committed by the producer
+func (this *varBuffer) publishedLocked() int64 {
+	if len(this.producers) == 0 {
+		return sequence.InitialSequenceValue
+	}
+
+	cursor, _ := this.producers[0].seq.Get()
+	return cursor
+}
+
+// putRecord writes the header and data of the record starting at seq
+func (this *varBuffer) putRecord(seq int64, flags uint32, data []byte) {
+	index := seq & this.mask
+
+	binary.LittleEndian.PutUint32(this.buffer[index:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(this.buffer[index+4:], flags)
+	copy(this.buffer[index+RecordHeaderSize:], data)
+}
+
+// putPadding fills the buffer from seq up to its end with a padding record
+func (this *varBuffer) putPadding(seq int64) {
+	index := seq & this.mask
+
+	binary.LittleEndian.PutUint32(this.buffer[index:], uint32(this.size-index-RecordHeaderSize))
+	binary.LittleEndian.PutUint32(this.buffer[index+4:], flagPadding)
+}
+
+// padding returns the number of bytes to skip before a record of n bytes can be put at
+// seq without wrapping around the end of the buffer, or 0 if it fits.
+func (this *varBuffer) padding(seq int64, n int) int {
+	index := seq & this.mask
+
+	if index+int64(n) <= this.size {
+		return 0
+	}
+
+	return int(this.size - index)
+}
+
+// getRecord returns the data of the record starting at seq, and whether it's padding.
+// Records never wrap around, so a size reaching past the end of the buffer means the
+// header was overwritten, and ErrRecordInvalid is returned.
+func (this *varBuffer) getRecord(seq int64) ([]byte, bool, error) {
+	index := seq & this.mask
+
+	n := int64(binary.LittleEndian.Uint32(this.buffer[index:]))
+	flags := binary.LittleEndian.Uint32(this.buffer[index+4:])
+
+	start := index + RecordHeaderSize
+	if n > this.size-start {
+		return nil, false, ErrRecordInvalid
+	}
+
+	return this.buffer[start : start+n], flags&flagPadding != 0, nil
+}
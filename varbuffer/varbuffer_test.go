@@ -0,0 +1,342 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package varbuffer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/reducedb/ringbuffer"
+	"github.com/reducedb/ringbuffer/ringtest"
+	"log"
+	"sync/atomic"
+	"testing"
+)
+
+var _ = log.Ldate
+
+func TestNew(t *testing.T) {
+	for _, size := range []int{0, 8, 24, 1000} {
+		if _, err := New(size); err != ErrInvalidBufferSize {
+			t.Fatalf("Size %d: expect ErrInvalidBufferSize, got %v", size, err)
+		}
+	}
+
+	r, err := New(64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.NewProducer(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.NewProducer(); err != ErrMaxProducerCountExceeded {
+		t.Fatalf("Expect ErrMaxProducerCountExceeded, got %v", err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	ringtest.Conformance(t, ringtest.Engine{
+		New: func() (ringbuffer.RingBuffer, error) {
+			return New(128)
+		},
+		Entry: func(i int) interface{} {
+			return bytes.Repeat([]byte{byte(i)}, i%20)
+		},
+		Check: func(v interface{}, i int) bool {
+			return bytes.Equal(v.([]byte), bytes.Repeat([]byte{byte(i)}, i%20))
+		},
+		ErrClosed: ErrClosed,
+	})
+}
+
+func TestRecordSize(t *testing.T) {
+	for size, expected := range map[int]int{0: 8, 1: 16, 8: 16, 9: 24, 100: 112} {
+		if n := RecordSize(size); n != expected {
+			t.Fatalf("Size %d: expect %d bytes, got %d", size, expected, n)
+		}
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	r, err := New(128)
+	p, c := ringtest.Open(t, r, err)
+
+	if _, err := p.Put("string"); err != ErrDataInvalid {
+		t.Fatalf("Expect ErrDataInvalid, got %v", err)
+	}
+
+	if _, err := p.Put(make([]byte, 121)); err != ErrDataExceedsMaxSize {
+		t.Fatalf("Expect ErrDataExceedsMaxSize, got %v", err)
+	}
+
+	// 48 + 48 bytes leave 32 bytes before the end of the buffer, so the 3rd record of
+	// 40 bytes is put after 32 bytes of padding
+	for i, n := range []int{40, 35, 30, 0, 40} {
+		data := bytes.Repeat([]byte{byte(i)}, n)
+
+		if _, err := p.Put(data); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := c.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(out.([]byte), data) {
+			t.Fatalf("Expect %v, got %v", data, out)
+		}
+	}
+
+	if cursor, _ := p.(*producer).seq.Get(); cursor != 223 {
+		t.Fatalf("Expect producer cursor == 223, got %d", cursor)
+	}
+}
+
+func TestPadding(t *testing.T) {
+	r, err := New(128)
+	p, c := ringtest.Open(t, r, err)
+
+	for _, n := range []int{40, 40} {
+		if _, err := p.Put(make([]byte, n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 96 bytes are used, so the next record of 40 bytes is put at the start
+	if _, err := c.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := p.Put(bytes.Repeat([]byte{1}, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 40+32 {
+		t.Fatalf("Expect 72 bytes used, got %d", n)
+	}
+
+	out, err := c.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The data is not copied
+	if &out.([]byte)[0] != &p.(*producer).buffer.buffer[RecordHeaderSize] {
+		t.Fatal("Expect the data to point to the start of the buffer")
+	}
+}
+
+func TestMixedSizes(t *testing.T) {
+	r, err := New(256)
+	p, c := ringtest.Open(t, r, err)
+
+	// From empty records to records that take most of the buffer, which often need
+	// padding in front of them
+	sizes := []int{0, 1, 7, 8, 9, 100, 200, 3, 248, 17, 120, 121, 64}
+
+	const count = 5000
+
+	errc := make(chan error, 1)
+	used := make(chan int, 1)
+
+	go func() {
+		total := 0
+
+		for i := 0; i < count; i++ {
+			n, err := p.Put(bytes.Repeat([]byte{byte(i)}, sizes[i%len(sizes)]))
+			if err != nil {
+				errc <- err
+				return
+			}
+
+			total += n
+		}
+
+		used <- total
+		errc <- p.(Producer).Close()
+	}()
+
+	for i := 0; i < count; i++ {
+		out, err := c.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(out.([]byte), bytes.Repeat([]byte{byte(i)}, sizes[i%len(sizes)])) {
+			t.Fatalf("Record %d: bytes not the same", i)
+		}
+	}
+
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	// The sequences count the bytes used by the records and the padding
+	total := <-used
+
+	if cursor, _ := p.(*producer).seq.Get(); cursor != int64(total)-1 {
+		t.Fatalf("Expect producer cursor == %d, got %d", total-1, cursor)
+	}
+
+	if _, err := c.Get(); err != ErrClosed {
+		t.Fatalf("Expect ErrClosed, got %v", err)
+	}
+}
+
+func TestLateConsumer(t *testing.T) {
+	r, err := New(64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without consumers the producer overwrites the records freely
+	for i := 0; i < 10; i++ {
+		if _, err := p.Put(make([]byte, 20)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := r.NewConsumer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Put([]byte("next")); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := c.Get(); err != nil {
+		t.Fatal(err)
+	} else if string(out.([]byte)) != "next" {
+		t.Fatalf("Expect the next record, got %q", out)
+	}
+}
+
+func TestRecordInvalid(t *testing.T) {
+	r, err := New(64)
+	p, c := ringtest.Open(t, r, err)
+
+	if _, err := p.Put([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A header overwritten under the consumer may hold any size
+	binary.LittleEndian.PutUint32(p.(*producer).buffer.buffer, 60)
+
+	if _, err := c.Get(); err != ErrRecordInvalid {
+		t.Fatalf("Expect ErrRecordInvalid, got %v", err)
+	}
+}
+
+func TestConsumerWhileProducing(t *testing.T) {
+	r, err := New(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := r.NewProducer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := []int{4, 9, 100, 17, 60, 4, 200}
+
+	var stop int32
+	errc := make(chan error, 1)
+
+	// Each record starts with its number, so the consumers can tell where they are
+	go func() {
+		for i := 0; atomic.LoadInt32(&stop) == 0; i++ {
+			data := make([]byte, sizes[i%len(sizes)])
+			binary.LittleEndian.PutUint32(data, uint32(i))
+
+			if _, err := p.Put(data); err != nil {
+				errc <- err
+				return
+			}
+		}
+
+		errc <- nil
+	}()
+
+	for k := 0; k < 1000; k++ {
+		c, err := r.NewConsumer()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var last int
+
+		for j := 0; j < 20; j++ {
+			out, err := c.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data := out.([]byte)
+			i := int(binary.LittleEndian.Uint32(data))
+
+			if j > 0 && i != last+1 {
+				t.Fatalf("Consumer %d: expect record %d, got %d", k, last+1, i)
+			}
+
+			if len(data) != sizes[i%len(sizes)] {
+				t.Fatalf("Consumer %d: record %d has %d bytes, expect %d", k, i, len(data), sizes[i%len(sizes)])
+			}
+
+			last = i
+		}
+
+		c.(Consumer).Close()
+	}
+
+	atomic.StoreInt32(&stop, 1)
+
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkProducerConsumer(b *testing.B) {
+	r, err := New(1 << 16)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	c, _ := r.NewConsumer()
+	p, _ := r.NewProducer()
+
+	data := make([]byte, 100)
+	n := b.N
+
+	go func() {
+		for i := 0; i < n; i++ {
+			if _, err := p.Put(data[:i%len(data)]); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	}()
+
+	b.SetBytes(int64(len(data) / 2))
+
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Get(); err != nil {
+			b.Error(err)
+			return
+		}
+	}
+}
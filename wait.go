@@ -0,0 +1,119 @@
+// Copyright (c) 2013 Zhen, LLC. http://zhen.io. All rights reserved.
+// Use of this source code is governed by the Apache 2.0 license.
+
+package ringbuffer
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WaitStrategy decides what producers and consumers do while they wait for a sequence
+// to become available, trading latency for CPU usage.
+type WaitStrategy interface {
+	// Wait is called each time the sequence is found to be unavailable. retries is the
+	// number of times it has been called for the same sequence, starting at 1.
+	Wait(retries int)
+
+	// Signal is called after a sequence is committed, to wake up the waiters.
+	Signal()
+}
+
+type busySpinWait struct{}
+
+// NewBusySpinWait returns a WaitStrategy that keeps checking the sequence without ever
+// giving up the CPU. It has the lowest latency, but each waiter uses a whole CPU and it
+// may starve the goroutine it's waiting on if GOMAXPROCS is too small.
+func NewBusySpinWait() WaitStrategy {
+	return busySpinWait{}
+}
+
+func (this busySpinWait) Wait(retries int) {}
+
+func (this busySpinWait) Signal() {}
+
+type yieldingWait struct{}
+
+// NewYieldingWait returns a WaitStrategy that yields the processor to other goroutines
+// between checks.
+func NewYieldingWait() WaitStrategy {
+	return yieldingWait{}
+}
+
+func (this yieldingWait) Wait(retries int) {
+	runtime.Gosched()
+}
+
+func (this yieldingWait) Signal() {}
+
+type sleepingWait struct {
+	spins int
+	sleep time.Duration
+}
+
+// NewSleepingWait returns a WaitStrategy that yields for the first spins checks, and
+// then sleeps for d between checks.
+func NewSleepingWait(spins int, d time.Duration) WaitStrategy {
+	return &sleepingWait{
+		spins: spins,
+		sleep: d,
+	}
+}
+
+func (this *sleepingWait) Wait(retries int) {
+	if retries <= this.spins {
+		runtime.Gosched()
+	} else {
+		time.Sleep(this.sleep)
+	}
+}
+
+func (this *sleepingWait) Signal() {}
+
+type blockingWait struct {
+	waiters int32
+	mutex   sync.Mutex
+	ch      chan struct{}
+	timeout time.Duration
+}
+
+// NewBlockingWait returns a WaitStrategy that blocks the waiters until a sequence is
+// committed. A waiter that checked the sequence right before it was committed misses
+// the signal, so it never blocks for more than timeout.
+func NewBlockingWait(timeout time.Duration) WaitStrategy {
+	return &blockingWait{
+		ch:      make(chan struct{}),
+		timeout: timeout,
+	}
+}
+
+func (this *blockingWait) Wait(retries int) {
+	atomic.AddInt32(&this.waiters, 1)
+	defer atomic.AddInt32(&this.waiters, -1)
+
+	this.mutex.Lock()
+	ch := this.ch
+	this.mutex.Unlock()
+
+	t := time.NewTimer(this.timeout)
+
+	select {
+	case <-ch:
+	case <-t.C:
+	}
+
+	t.Stop()
+}
+
+func (this *blockingWait) Signal() {
+	if atomic.LoadInt32(&this.waiters) == 0 {
+		return
+	}
+
+	this.mutex.Lock()
+	close(this.ch)
+	this.ch = make(chan struct{})
+	this.mutex.Unlock()
+}